@@ -0,0 +1,195 @@
+package accounts
+
+import (
+	"errors"
+	"log"
+	"path"
+	"time"
+
+	"github.com/coreos/bbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const DbName = "accounts.db"
+const DefaultRequestInterval = time.Hour
+
+// OpenTimeout is how long Open waits for another process to release the
+// database
+var OpenTimeout = 5 * time.Second
+
+const (
+	EmailHashPrefix  = "email-hash."  // email to password hash
+	TokenHashPrefix  = "token-hash."  // validation token to requested password hash
+	TokenEmailPrefix = "token-email." // validation token to email
+	RequestedPrefix  = "requested."   // email to date of the last password request
+)
+
+var (
+	ErrNoAccount     = errors.New("No such account")
+	ErrBadPassword   = errors.New("Invalid password")
+	ErrTooEarly      = errors.New("A password was requested recently for this address")
+	ErrDatabaseInUse = errors.New("Database in use, stop the server first")
+)
+
+// Accounts stores password hashes for validated e-mail addresses. A password
+// is only set once the address owner confirmed it through the e-mail
+// validation flow.
+type Accounts struct {
+	StorageDir string
+	// Minimum time between two password requests for the same address
+	RequestInterval time.Duration
+	db              *bolt.DB
+}
+
+func (a *Accounts) Open() error {
+	var err error
+	a.Close()
+	a.db, err = bolt.Open(path.Join(a.StorageDir, DbName), 0644, &bolt.Options{Timeout: OpenTimeout})
+	if err == bolt.ErrTimeout {
+		return ErrDatabaseInUse
+	}
+	return err
+}
+
+func (a *Accounts) Close() error {
+	if a.db != nil {
+		err := a.db.Close()
+		a.db = nil
+		return err
+	}
+	return nil
+}
+
+// CheckPassword returns ErrNoAccount if no password is set for the e-mail
+// address, and ErrBadPassword if pass does not match.
+func (a *Accounts) CheckPassword(email, pass string) error {
+	var hash []byte
+	err := a.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("accounts"))
+		if bucket == nil {
+			return ErrNoAccount
+		}
+
+		hash = bucket.Get(encodeStrKey(EmailHashPrefix, email))
+		if hash == nil {
+			return ErrNoAccount
+		}
+		hash = append([]byte(nil), hash...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = bcrypt.CompareHashAndPassword(hash, []byte(pass))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrBadPassword
+	}
+	return err
+}
+
+// RequestPassword records a password for the e-mail address that will be set
+// when the validation token comes back. It returns ErrTooEarly if another
+// password was requested for the address less than RequestInterval ago.
+func (a *Accounts) RequestPassword(email, pass, token string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return a.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("accounts"))
+		panicIfError(err)
+
+		now := time.Now()
+		if last, err := decodeTime(bucket.Get(encodeStrKey(RequestedPrefix, email))); err == nil && now.Before(last.Add(a.requestInterval())) {
+			return ErrTooEarly
+		}
+
+		panicIfError(bucket.Put(encodeStrKey(RequestedPrefix, email), encodeTime(now)))
+		panicIfError(bucket.Put(encodeStrKey(TokenHashPrefix, token), hash))
+		return bucket.Put(encodeStrKey(TokenEmailPrefix, token), []byte(email))
+	})
+}
+
+func (a *Accounts) requestInterval() time.Duration {
+	if a.RequestInterval <= 0 {
+		return DefaultRequestInterval
+	}
+	return a.RequestInterval
+}
+
+// ConfirmPassword sets the password requested with the validated token. It
+// tells if a password was waiting for this token.
+func (a *Accounts) ConfirmPassword(email, token string) (confirmed bool, err error) {
+	err = a.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("accounts"))
+		panicIfError(err)
+
+		hash := bucket.Get(encodeStrKey(TokenHashPrefix, token))
+		if hash == nil || string(bucket.Get(encodeStrKey(TokenEmailPrefix, token))) != email {
+			return nil
+		}
+
+		panicIfError(bucket.Put(encodeStrKey(EmailHashPrefix, email), hash))
+		panicIfError(bucket.Delete(encodeStrKey(TokenHashPrefix, token)))
+		panicIfError(bucket.Delete(encodeStrKey(TokenEmailPrefix, token)))
+		confirmed = true
+		return nil
+	})
+	return
+}
+
+// RemovePendingTokens forgets the passwords requested with any of the tokens
+// and returns the number of requests removed.
+func (a *Accounts) RemovePendingTokens(tokens []string) (n int, err error) {
+	err = a.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("accounts"))
+		if bucket == nil {
+			return nil
+		}
+
+		for _, token := range tokens {
+			if bucket.Get(encodeStrKey(TokenHashPrefix, token)) == nil {
+				continue
+			}
+			panicIfError(bucket.Delete(encodeStrKey(TokenHashPrefix, token)))
+			panicIfError(bucket.Delete(encodeStrKey(TokenEmailPrefix, token)))
+			n++
+		}
+		return nil
+	})
+	return
+}
+
+// ResetPassword removes the password of the e-mail address, a new one can
+// then be set through the e-mail flow.
+func (a *Accounts) ResetPassword(email string) error {
+	return a.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("accounts"))
+		panicIfError(err)
+
+		if bucket.Get(encodeStrKey(EmailHashPrefix, email)) == nil {
+			return ErrNoAccount
+		}
+		return bucket.Delete(encodeStrKey(EmailHashPrefix, email))
+	})
+}
+
+func encodeStrKey(prefix, data string) []byte {
+	return []byte(prefix + data)
+}
+
+func encodeTime(t time.Time) []byte {
+	return []byte(t.Format(time.RFC3339))
+}
+
+func decodeTime(d []byte) (time.Time, error) {
+	return time.Parse(time.RFC3339, string(d))
+}
+
+func panicIfError(err error) {
+	if err != nil {
+		log.Panic(err)
+	}
+}
@@ -1,22 +1,44 @@
 package articles
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"bufio"
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"path"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/coreos/bbolt"
+
+	"github.com/mildred/newsweb/message"
 )
 
 const DbName = "index.db"
 
+// OpenTimeout is how long Open waits for another process to release the
+// database
+var OpenTimeout = 5 * time.Second
+
+var ErrDatabaseInUse = errors.New("Database in use, stop the server first")
+
 type Articles struct {
 	StorageDir string
-	db         *bolt.DB
+	// Server name used in Xref headers
+	ServerName string
+	// How long the Message-ID of a removed article is refused, see
+	// DefaultMsgIdHistory
+	MsgIdHistory time.Duration
+	db           *bolt.DB
+	// Set by Recover, the running mark is removed on Close
+	running bool
+	// Held while article files are installed and indexed, or checked and
+	// removed, so that a file is never removed as it gets a new reference
+	filesLock sync.Mutex
 }
 
 type Group struct {
@@ -25,57 +47,132 @@ type Group struct {
 	Count       int64
 	High        int64
 	Low         int64
+	Policy      Policy
+	Moderators  []string
+	// Creation time, zero for groups created before it was recorded
+	Created time.Time
+	Expiry  Expiry
 }
 
 func (ar *Articles) Open() error {
 	var err error
 	ar.Close()
-	ar.db, err = bolt.Open(path.Join(ar.StorageDir, DbName), 0644, nil)
-	return err
+	ar.db, err = bolt.Open(path.Join(ar.StorageDir, DbName), 0644, &bolt.Options{Timeout: OpenTimeout})
+	if err == bolt.ErrTimeout {
+		return ErrDatabaseInUse
+	} else if err != nil {
+		return err
+	}
+	return ar.migrate()
 }
 
 func (ar *Articles) Close() error {
+	if ar.db != nil && ar.running {
+		err := ar.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("meta")).Delete(KeyRunningSince)
+		})
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+		ar.running = false
+	}
 	if ar.db != nil {
-		return ar.db.Close()
+		err := ar.db.Close()
 		ar.db = nil
+		return err
 	}
 	return nil
 }
 
 func (ar *Articles) ListGroups() (res []*Group, err error) {
-	err = ar.db.View(func(tx *bolt.Tx) error {
-		groups := tx.Bucket([]byte("groups"))
-		if groups == nil {
+	err = ar.EachGroup("", func(group *Group) error {
+		res = append(res, group)
+		return nil
+	})
+	return res, err
+}
+
+// EachGroupBatch is the number of groups EachGroup reads per transaction
+const EachGroupBatch = 100
+
+// EachGroup calls fn for every group matching the wildmat, an empty wildmat
+// matching all groups, in name order. Groups are read from a cursor in batches
+// of EachGroupBatch, and fn is called between the read transactions so that it
+// can take its time. Iteration stops at the first error returned by fn.
+func (ar *Articles) EachGroup(wildmat string, fn func(*Group) error) error {
+	var after []byte
+	for {
+		var batch []*Group
+		var done bool
+		err := ar.db.View(func(tx *bolt.Tx) error {
+			groups := tx.Bucket([]byte("groups"))
+			if groups == nil {
+				done = true
+				return nil
+			}
+
+			cur := groups.Cursor()
+			k, v := cur.First()
+			if after != nil {
+				k, v = cur.Seek(after)
+				if bytes.Equal(k, after) {
+					k, v = cur.Next()
+				}
+			}
+
+			for n := 0; k != nil && n < EachGroupBatch; k, v = cur.Next() {
+				n++
+				// Keys are only valid during the transaction
+				after = append(after[:0], k...)
+				if v != nil {
+					continue
+				} else if wildmat != "" && !MatchWildmat(wildmat, string(k)) {
+					continue
+				}
+				var group = &Group{Name: string(k)}
+				readGroup(groups.Bucket(k), group)
+				batch = append(batch, group)
+			}
+			done = k == nil
 			return nil
+		})
+		if err != nil {
+			return err
 		}
 
-		cur := groups.Cursor()
-		for k, v := cur.First(); k != nil; k, v = cur.Next() {
-			if v != nil {
-				continue
+		for _, group := range batch {
+			err = fn(group)
+			if err != nil {
+				return err
 			}
-			bucket := groups.Bucket(k)
-			var group = new(Group)
-			group.Name = string(k)
-			readGroup(bucket, group)
-			res = append(res, group)
 		}
-		return nil
-	})
-
-	return res, err
+		if done {
+			return nil
+		}
+	}
 }
 
 var ErrNoGroup = errors.New("No such group")
 
+// nextNum returns the number of the next article posted to the group
+func nextNum(bucket *bolt.Bucket) int64 {
+	next, err := btoi(bucket.Get(KeyGroupNextNum))
+	if err != nil {
+		return 1
+	}
+	return next
+}
+
+// readGroup reads the group marks. An empty group has its high mark one below
+// its low mark, as specified by RFC 3977.
 func readGroup(bucket *bolt.Bucket, group *Group) {
 	first, err := btoi(bucket.Get(KeyGroupFirst))
 	if err != nil {
-		first = 1
+		first = nextNum(bucket)
 	}
 	last, err := btoi(bucket.Get(KeyGroupLast))
 	if err != nil {
-		last = 0
+		last = first - 1
 	}
 	count, err := btoi(bucket.Get(KeyGroupCount))
 	if err != nil {
@@ -87,26 +184,22 @@ func readGroup(bucket *bolt.Bucket, group *Group) {
 	group.High = last
 	group.Count = count
 	group.Description = descr
-	log.Printf("DEBUG: read group %s %d %d %d - %s", group.Name, group.Low, group.High, group.Count, group.Description)
+	group.Policy = readPolicy(bucket)
+	group.Moderators = readModerators(bucket)
+	group.Expiry = readExpiry(bucket)
+	if created, err := btoi(bucket.Get(KeyGroupCreated)); err == nil {
+		group.Created = time.Unix(0, created)
+	}
 }
 
 func (ar *Articles) GetGroup(name string) (group *Group, err error) {
-	group = &Group{
-		Name:        name,
-		Description: name,
-		Low:         0,
-	}
 	err = ar.db.View(func(tx *bolt.Tx) error {
-		groups := tx.Bucket([]byte("groups"))
-		if groups == nil {
-			group = nil
-			return ErrNoGroup
+		grp, err := groupBucket(tx, name)
+		if err != nil {
+			return err
 		}
 
-		grp := groups.Bucket([]byte(name))
-		if grp == nil {
-			return ErrNoGroup
-		}
+		group = &Group{Name: name}
 		readGroup(grp, group)
 		return nil
 	})
@@ -182,68 +275,156 @@ func (ar *Articles) getArticleFromHash(groupName string, hash string) (io.ReadCl
 	return f, nil
 }
 
+// Post stores an article held in memory, see PostReader
 func (ar *Articles) Post(groupNames []string, msgId string, data []byte) error {
-	binHash := sha256.Sum256(data)
-	hash := hex.EncodeToString(binHash[:])
-	dir, fname := ar.getPath(hash)
+	return ar.PostReader(groupNames, msgId, bytes.NewReader(data))
+}
 
-	err := os.MkdirAll(dir, 0755)
+// PostReader stores an article and indexes it in every group at once. An Xref
+// header listing the article number in each group is added. The Message-ID
+// must be set and unique, or ErrDuplicateMsgId is returned.
+//
+// Article numbers are reserved first, then the article is streamed to a
+// synced temporary file moved in place under its SHA-256 hash, and only then
+// indexed. A crash can leave unused numbers or an orphan file, removed at
+// startup, but never an index entry without its file.
+func (ar *Articles) PostReader(groupNames []string, msgId string, r io.Reader) error {
+	return ar.postReader(groupNames, msgId, r, "")
+}
+
+// postReader stores an article, see PostReader. If supersedes is set and
+// known, that article is replaced in the transaction indexing the new one.
+func (ar *Articles) postReader(groupNames []string, msgId string, r io.Reader, supersedes string) error {
+	if msgId == "" {
+		return errors.New("Missing Message-ID")
+	} else if len(groupNames) == 0 {
+		return errors.New("Missing Newsgroups")
+	}
+
+	br := bufio.NewReader(r)
+	rawHeader, err := message.ReadHeader(br)
 	if err != nil {
 		return err
 	}
+	rawHeader = message.RemoveHeader(rawHeader, message.HeaderXref)
+	header := readHeader(rawHeader)
+	refs := readReferences(header)
+	now := time.Now()
 
-	f, err := os.Create(path.Join(dir, fname))
+	nums, err := ar.reserveNums(groupNames, msgId, now)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	_, err = f.Write(data)
+	var xref = []string{ar.ServerName}
+	for i, groupName := range groupNames {
+		xref = append(xref, fmt.Sprintf("%s:%d", groupName, nums[i]))
+	}
+	rawHeader = message.AddHeader(rawHeader, message.HeaderXref, strings.Join(xref, " "))
+
+	tmpName, hash, size, lines, err := ar.writeArticle(rawHeader, br)
 	if err != nil {
 		return err
 	}
+	overview := readOverview(header, size, lines).encode()
 
-	return ar.db.Update(func(tx *bolt.Tx) error {
-		groups, err := tx.CreateBucketIfNotExists([]byte("groups"))
-		panicIfError(err)
+	ar.filesLock.Lock()
+	defer ar.filesLock.Unlock()
 
-		for _, groupName := range groupNames {
-			grp, err := groups.CreateBucketIfNotExists([]byte(groupName))
+	created, err := ar.installArticle(tmpName, hash)
+	if err != nil {
+		return err
+	}
+
+	var unreferenced []string
+	err = ar.db.Update(func(tx *bolt.Tx) error {
+		err := indexMsgId(tx, msgId, hash, groupNames, now)
+		if err != nil {
+			return err
+		}
+
+		for i, groupName := range groupNames {
+			grp, err := groupBucket(tx, groupName)
 			if err != nil {
 				return err
 			}
 
-			last, err := btoi(grp.Get(KeyGroupLast))
-			if err != nil {
-				last = 1
-				panicIfError(grp.Put(KeyGroupFirst, itob(1)))
-			}
+			num := nums[i]
 			count, err := btoi(grp.Get(KeyGroupCount))
 			if err != nil {
 				count = 0
 			}
-			num := last
-			last++
 			count++
 
-			log.Printf("DEBUG: update group %s ? %d %d", groupName, last, count)
-
+			// Numbers are reserved in order but concurrent posts can be
+			// indexed in any order
+			if first, err := btoi(grp.Get(KeyGroupFirst)); err != nil || num < first {
+				panicIfError(grp.Put(KeyGroupFirst, itob(num)))
+			}
+			if last, err := btoi(grp.Get(KeyGroupLast)); err != nil || num > last {
+				panicIfError(grp.Put(KeyGroupLast, itob(num)))
+			}
 			panicIfError(grp.Put(KeyGroupCount, itob(count)))
-			panicIfError(grp.Put(KeyGroupLast, itob(last)))
 			panicIfError(grp.Put(encodeIntKey(NumFilePrefix, num), []byte(hash)))
 			panicIfError(grp.Put(encodeIntKey(NumMsgIdPrefix, num), []byte(msgId)))
 			panicIfError(grp.Put(encodeStrKey(MsgIdFilePrefix, msgId), []byte(hash)))
 			panicIfError(grp.Put(encodeStrKey(MsgIdNumPrefix, msgId), itob(num)))
+			panicIfError(grp.Put(encodeIntKey(NumOverviewPrefix, num), overview))
+			indexThread(grp, msgId, num, refs, now)
+			addFileRef(tx, hash, groupName, num)
+		}
+
+		if supersedes != "" {
+			if groups, found := msgIdGroups(tx, supersedes); found {
+				unreferenced = removeFromGroupsTx(tx, supersedes, groups, msgId)
+			}
+		}
+		return nil
+	})
+	if err != nil && created {
+		dir, fname := ar.getPath(hash)
+		if rmErr := os.Remove(path.Join(dir, fname)); rmErr != nil {
+			log.Printf("ERROR: %v", rmErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return ar.releaseFilesLocked(unreferenced)
+}
+
+// reserveNums allocates an article number in each group, creating groups as
+// needed
+func (ar *Articles) reserveNums(groupNames []string, msgId string, now time.Time) (nums []int64, err error) {
+	err = ar.db.Update(func(tx *bolt.Tx) error {
+		if msgIdKnown(tx, msgId) {
+			return ErrDuplicateMsgId
+		}
+
+		for _, groupName := range groupNames {
+			grp, err := createGroupBucket(tx, groupName, now)
+			if err != nil {
+				return err
+			}
+
+			num := nextNum(grp)
+			panicIfError(grp.Put(KeyGroupNextNum, itob(num+1)))
+			nums = append(nums, num)
 		}
 		return nil
 	})
+	return
 }
 
 var (
-	KeyGroupFirst = []byte("first")
-	KeyGroupLast  = []byte("last")
-	KeyGroupCount = []byte("count")
-	KeyGroupDescr = []byte("description")
+	KeyGroupNextNum    = []byte("next-num")
+	KeyGroupFirst      = []byte("first")
+	KeyGroupLast       = []byte("last")
+	KeyGroupCount      = []byte("count")
+	KeyGroupDescr      = []byte("description")
+	KeyGroupPolicy     = []byte("policy")
+	KeyGroupModerators = []byte("moderators")
+	KeyGroupCreated    = []byte("created")
 )
 
 const (
@@ -0,0 +1,625 @@
+package articles
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/bbolt"
+)
+
+func openTestArticles(t *testing.T) (*Articles, func()) {
+	dir, err := ioutil.TempDir("", "newsweb-articles")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ar := &Articles{StorageDir: dir, ServerName: "news.example.org"}
+	err = ar.Open()
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return ar, func() {
+		ar.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func testArticle(msgId string, groups []string) []byte {
+	return []byte(fmt.Sprintf("From: author@example.org\nNewsgroups: %s\nSubject: Test\nMessage-ID: %s\n\nBody of %s\n",
+		strings.Join(groups, ","), msgId, msgId))
+}
+
+type groupMarks struct {
+	Low, High, Count int64
+}
+
+func checkGroup(t *testing.T, ar *Articles, name string, expected groupMarks) {
+	grp, err := ar.GetGroup(name)
+	if err != nil {
+		t.Errorf("group %s: %v", name, err)
+		return
+	}
+	actual := groupMarks{grp.Low, grp.High, grp.Count}
+	if actual != expected {
+		t.Errorf("group %s: expected %+v, got %+v", name, expected, actual)
+	}
+}
+
+func TestPostNumbering(t *testing.T) {
+	var tests = []struct {
+		name   string
+		posts  [][]string
+		groups map[string]groupMarks
+		nums   map[string]int64
+	}{
+		{
+			name:   "first post",
+			posts:  [][]string{{"a.b"}},
+			groups: map[string]groupMarks{"a.b": {1, 1, 1}},
+			nums:   map[string]int64{"a.b <0@test>": 1},
+		},
+		{
+			name:   "consecutive posts",
+			posts:  [][]string{{"a.b"}, {"a.b"}, {"a.b"}},
+			groups: map[string]groupMarks{"a.b": {1, 3, 3}},
+			nums:   map[string]int64{"a.b <0@test>": 1, "a.b <2@test>": 3},
+		},
+		{
+			name:  "cross-post",
+			posts: [][]string{{"a.b"}, {"a.b", "c.d"}, {"c.d"}},
+			groups: map[string]groupMarks{
+				"a.b": {1, 2, 2},
+				"c.d": {1, 2, 2},
+			},
+			nums: map[string]int64{"a.b <1@test>": 2, "c.d <1@test>": 1, "c.d <2@test>": 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ar, cleanup := openTestArticles(t)
+			defer cleanup()
+
+			for i, groups := range test.posts {
+				msgId := fmt.Sprintf("<%d@test>", i)
+				err := ar.Post(groups, msgId, testArticle(msgId, groups))
+				if err != nil {
+					t.Fatalf("post %s: %v", msgId, err)
+				}
+			}
+
+			for name, marks := range test.groups {
+				checkGroup(t, ar, name, marks)
+			}
+
+			for key, expected := range test.nums {
+				groupMsgId := strings.Fields(key)
+				num, err := ar.ArticleNum(groupMsgId[0], groupMsgId[1])
+				if err != nil {
+					t.Errorf("%s: %v", key, err)
+				} else if num != expected {
+					t.Errorf("%s: expected number %d, got %d", key, expected, num)
+				}
+			}
+		})
+	}
+}
+
+func TestEmptyGroupMarks(t *testing.T) {
+	ar, cleanup := openTestArticles(t)
+	defer cleanup()
+
+	err := ar.CreateGroup("a.empty", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGroup(t, ar, "a.empty", groupMarks{1, 0, 0})
+}
+
+func TestGetMissingGroup(t *testing.T) {
+	var tests = []struct {
+		name   string
+		groups []string
+	}{
+		{"no groups", nil},
+		{"other groups", []string{"a.b", "a.c"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ar, cleanup := openTestArticles(t)
+			defer cleanup()
+
+			for _, name := range test.groups {
+				err := ar.CreateGroup(name, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err := ar.GetGroup("a.missing")
+			if err != ErrNoGroup {
+				t.Errorf("expected ErrNoGroup, got %v", err)
+			}
+		})
+	}
+}
+
+// TestMigrateNumbering opens an index written before the next-num counter and
+// the global Message-ID index: the last mark is one past the highest article.
+func TestMigrateNumbering(t *testing.T) {
+	var tests = []struct {
+		name     string
+		nums     []int64
+		oldLast  int64
+		expected groupMarks
+		next     int64
+	}{
+		{"empty group", nil, 1, groupMarks{1, 0, 0}, 1},
+		{"contiguous articles", []int64{1, 2, 3}, 4, groupMarks{1, 3, 3}, 4},
+		{"removed articles", []int64{2, 5}, 7, groupMarks{2, 5, 2}, 7},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "newsweb-articles")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			db, err := bolt.Open(path.Join(dir, DbName), 0644, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = db.Update(func(tx *bolt.Tx) error {
+				groups, err := tx.CreateBucket([]byte("groups"))
+				panicIfError(err)
+				grp, err := groups.CreateBucket([]byte("a.old"))
+				panicIfError(err)
+
+				panicIfError(grp.Put(KeyGroupLast, itob(test.oldLast)))
+				for _, num := range test.nums {
+					msgId := fmt.Sprintf("<old%d@test>", num)
+					hash := fmt.Sprintf("hash%d", num)
+					panicIfError(grp.Put(encodeIntKey(NumFilePrefix, num), []byte(hash)))
+					panicIfError(grp.Put(encodeIntKey(NumMsgIdPrefix, num), []byte(msgId)))
+					panicIfError(grp.Put(encodeStrKey(MsgIdFilePrefix, msgId), []byte(hash)))
+					panicIfError(grp.Put(encodeStrKey(MsgIdNumPrefix, msgId), itob(num)))
+				}
+				return nil
+			})
+			db.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ar := &Articles{StorageDir: dir, ServerName: "news.example.org"}
+			err = ar.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ar.Close()
+
+			checkGroup(t, ar, "a.old", test.expected)
+
+			for _, num := range test.nums {
+				msgId := fmt.Sprintf("<old%d@test>", num)
+				groups, found, err := ar.MsgIdGroups(msgId)
+				if err != nil {
+					t.Fatal(err)
+				} else if !found || len(groups) != 1 || groups[0] != "a.old" {
+					t.Errorf("%s: expected in a.old, got %v (found %v)", msgId, groups, found)
+				}
+			}
+
+			msgId := "<new@test>"
+			err = ar.Post([]string{"a.old"}, msgId, testArticle(msgId, []string{"a.old"}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			num, err := ar.ArticleNum("a.old", msgId)
+			if err != nil {
+				t.Fatal(err)
+			} else if num != test.next {
+				t.Errorf("expected next article %d, got %d", test.next, num)
+			}
+		})
+	}
+}
+
+func TestExpire(t *testing.T) {
+	var tests = []struct {
+		name   string
+		ages   []time.Duration
+		expiry Expiry
+		marks  groupMarks
+		// Remove the overviews, as for articles stored before them
+		noOverview bool
+	}{
+		{"nothing to expire", []time.Duration{3 * time.Hour, time.Hour}, Expiry{MaxAge: 4 * time.Hour}, groupMarks{1, 2, 2}, false},
+		{"oldest articles", []time.Duration{3 * time.Hour, 2 * time.Hour, 0}, Expiry{MaxAge: time.Hour}, groupMarks{3, 3, 1}, false},
+		{"old article after a recent one", []time.Duration{0, 2 * time.Hour, 0}, Expiry{MaxAge: time.Hour}, groupMarks{1, 3, 2}, false},
+		{"count", []time.Duration{0, 0, 0}, Expiry{MaxCount: 1}, groupMarks{3, 3, 1}, false},
+		{"age then count", []time.Duration{0, 0, 2 * time.Hour, 0}, Expiry{MaxAge: time.Hour, MaxCount: 2}, groupMarks{2, 4, 2}, false},
+		{"count without overview", []time.Duration{0, 0, 0}, Expiry{MaxCount: 1}, groupMarks{3, 3, 1}, true},
+		{"age without overview", []time.Duration{2 * time.Hour, 0}, Expiry{MaxAge: time.Hour}, groupMarks{2, 2, 1}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ar, cleanup := openTestArticles(t)
+			defer cleanup()
+
+			now := time.Now()
+			for i, age := range test.ages {
+				msgId := fmt.Sprintf("<%d@test>", i)
+				err := ar.Post([]string{"a.b"}, msgId, testArticle(msgId, []string{"a.b"}))
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = ar.db.Update(func(tx *bolt.Tx) error {
+					if test.noOverview {
+						grp, err := groupBucket(tx, "a.b")
+						panicIfError(err)
+						panicIfError(grp.Delete(encodeIntKey(NumOverviewPrefix, int64(i+1))))
+					}
+					return tx.Bucket([]byte("msgids")).Put(encodeStrKey(MsgIdArrivalPrefix, msgId), itob(now.Add(-age).UnixNano()))
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := ar.SetGroupExpiry("a.b", test.expiry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = ar.Expire(now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGroup(t, ar, "a.b", test.marks)
+		})
+	}
+}
+
+func TestSupersede(t *testing.T) {
+	var tests = []struct {
+		name   string
+		target string
+		marks  groupMarks
+		gone   bool
+	}{
+		{"known target", "<0@test>", groupMarks{2, 2, 1}, true},
+		{"unknown target", "<unknown@test>", groupMarks{1, 2, 2}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ar, cleanup := openTestArticles(t)
+			defer cleanup()
+
+			groups := []string{"a.b"}
+			err := ar.Post(groups, "<0@test>", testArticle("<0@test>", groups))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ar.Supersede(test.target, groups, "<1@test>", testArticle("<1@test>", groups))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkGroup(t, ar, "a.b", test.marks)
+			_, found, err := ar.MsgIdGroups("<0@test>")
+			if err != nil {
+				t.Fatal(err)
+			} else if found == test.gone {
+				t.Errorf("expected the original article found %v, got %v", !test.gone, found)
+			}
+			problems, err := ar.Fsck(false)
+			if err != nil || len(problems) > 0 {
+				t.Errorf("fsck: %v %v", problems, err)
+			}
+		})
+	}
+}
+
+func TestApplyGroupControl(t *testing.T) {
+	ar, cleanup := openTestArticles(t)
+	defer cleanup()
+
+	var steps = []struct {
+		msgId   string
+		control GroupControl
+		existed bool
+		err     error
+		policy  Policy
+		descr   string
+	}{
+		{"<new@test>", GroupControl{Group: "a.b", Description: "Test", Moderated: true}, false, nil, PolicyModerated, "Test"},
+		{"<new@test>", GroupControl{Group: "a.b", Remove: true}, false, ErrDuplicateMsgId, PolicyModerated, "Test"},
+		{"<public@test>", GroupControl{Group: "a.b"}, true, nil, PolicyPublic, "Test"},
+		{"<change@test>", GroupControl{Group: "a.b", Description: "Changed"}, true, nil, PolicyPublic, "Changed"},
+		{"<rm@test>", GroupControl{Group: "a.b", Remove: true}, true, nil, "", ""},
+	}
+
+	for _, step := range steps {
+		existed, err := ar.ApplyGroupControl(step.msgId, step.control)
+		if err != step.err {
+			t.Fatalf("%s: expected error %v, got %v", step.msgId, step.err, err)
+		} else if err == nil && existed != step.existed {
+			t.Errorf("%s: expected existed %v, got %v", step.msgId, step.existed, existed)
+		}
+
+		grp, err := ar.GetGroup("a.b")
+		if step.policy == "" {
+			if err != ErrNoGroup {
+				t.Errorf("%s: expected ErrNoGroup, got %v", step.msgId, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", step.msgId, err)
+		} else if grp.Policy != step.policy || grp.Description != step.descr {
+			t.Errorf("%s: expected policy %s and description %q, got %s and %q", step.msgId, step.policy, step.descr, grp.Policy, grp.Description)
+		}
+	}
+
+	known, err := ar.HasMsgId("<rm@test>")
+	if err != nil || !known {
+		t.Errorf("expected the control Message-ID to be known, got %v %v", known, err)
+	}
+	err = ar.Post([]string{"c.d"}, "<rm@test>", testArticle("<rm@test>", []string{"c.d"}))
+	if err != ErrDuplicateMsgId {
+		t.Errorf("expected ErrDuplicateMsgId posting a control Message-ID, got %v", err)
+	}
+}
+
+func TestEachGroupBatches(t *testing.T) {
+	ar, cleanup := openTestArticles(t)
+	defer cleanup()
+
+	var expected []string
+	for i := 0; i < 2*EachGroupBatch+5; i++ {
+		name := fmt.Sprintf("a.g%03d", i)
+		if i%2 == 0 {
+			name = fmt.Sprintf("b.g%03d", i)
+		} else {
+			expected = append(expected, name)
+		}
+		err := ar.CreateGroup(name, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var names []string
+	err := ar.EachGroup("a.*", func(grp *Group) error {
+		names = append(names, grp.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Errorf("expected %d groups in order, got %v", len(expected), names)
+	}
+
+	var stop = fmt.Errorf("stop")
+	var n int
+	err = ar.EachGroup("", func(grp *Group) error {
+		n++
+		if n == EachGroupBatch+1 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || n != EachGroupBatch+1 {
+		t.Errorf("expected to stop after %d groups, got %d: %v", EachGroupBatch+1, n, err)
+	}
+}
+
+func TestReindex(t *testing.T) {
+	ar, cleanup := openTestArticles(t)
+	defer cleanup()
+
+	const count = ReindexBatch + 3
+	for i := 0; i < count; i++ {
+		msgId := fmt.Sprintf("<%d@test>", i)
+		err := ar.Post([]string{"a.b"}, msgId, testArticle(msgId, []string{"a.b"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Remove the overviews as stored before they existed
+	err := ar.db.Update(func(tx *bolt.Tx) error {
+		grp, err := groupBucket(tx, "a.b")
+		if err != nil {
+			return err
+		}
+		for num := int64(1); num <= count; num++ {
+			panicIfError(grp.Delete(encodeIntKey(NumOverviewPrefix, num)))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := ar.Reindex()
+	if err != nil {
+		t.Fatal(err)
+	} else if n != count {
+		t.Errorf("expected %d articles reindexed, got %d", count, n)
+	}
+
+	ov, err := ar.GetOverview("a.b", 1, count)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ov) != count {
+		t.Fatalf("expected %d overviews, got %d", count, len(ov))
+	}
+	for i, o := range ov {
+		if msgId := fmt.Sprintf("<%d@test>", i); o.Num != int64(i+1) || o.MsgId != msgId {
+			t.Errorf("expected %d %s, got %d %s", i+1, msgId, o.Num, o.MsgId)
+		}
+	}
+}
+
+func TestForgottenMsgIds(t *testing.T) {
+	ar, cleanup := openTestArticles(t)
+	defer cleanup()
+	ar.MsgIdHistory = time.Hour
+
+	groups := []string{"a.b"}
+	for _, msgId := range []string{"<cancelled@test>", "<kept@test>"} {
+		err := ar.Post(groups, msgId, testArticle(msgId, groups))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := ar.Cancel("<cancelled@test>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ar.Post(groups, "<cancelled@test>", testArticle("<cancelled@test>", groups))
+	if err != ErrDuplicateMsgId {
+		t.Errorf("expected ErrDuplicateMsgId reposting a cancelled article, got %v", err)
+	}
+
+	var tests = []struct {
+		now     time.Time
+		cleaned int
+	}{
+		{time.Now(), 0},
+		{time.Now().Add(2 * time.Hour), 1},
+		{time.Now().Add(2 * time.Hour), 0},
+	}
+	for _, test := range tests {
+		n, err := ar.CleanForgottenMsgIds(test.now)
+		if err != nil {
+			t.Fatal(err)
+		} else if n != test.cleaned {
+			t.Errorf("expected %d Message-IDs cleaned, got %d", test.cleaned, n)
+		}
+	}
+
+	err = ar.Post(groups, "<cancelled@test>", testArticle("<cancelled@test>", groups))
+	if err != nil {
+		t.Errorf("expected the Message-ID to be accepted after its history, got %v", err)
+	}
+}
+
+func TestRemoveSupersedingArticle(t *testing.T) {
+	var tests = []struct {
+		name   string
+		remove func(ar *Articles) error
+	}{
+		{"cancel", func(ar *Articles) error {
+			return ar.Cancel("<2@test>")
+		}},
+		{"expire", func(ar *Articles) error {
+			err := ar.SetGroupExpiry("a.b", Expiry{MaxCount: 1})
+			if err == nil {
+				_, err = ar.Expire(time.Now())
+			}
+			return err
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ar, cleanup := openTestArticles(t)
+			defer cleanup()
+
+			// <0> is superseded by <1>, itself superseded by <2>
+			groups := []string{"a.b"}
+			err := ar.Post(groups, "<0@test>", testArticle("<0@test>", groups))
+			if err == nil {
+				err = ar.Supersede("<0@test>", groups, "<1@test>", testArticle("<1@test>", groups))
+			}
+			if err == nil {
+				err = ar.Supersede("<1@test>", groups, "<2@test>", testArticle("<2@test>", groups))
+			}
+			if err == nil {
+				err = ar.Post(groups, "<other@test>", testArticle("<other@test>", groups))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = test.remove(ar)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGroup(t, ar, "a.b", groupMarks{4, 4, 1})
+
+			problems, err := ar.Fsck(false)
+			if err != nil || len(problems) > 0 {
+				t.Errorf("fsck: %v %v", problems, err)
+			}
+		})
+	}
+}
+
+func TestRenameGroupNestedBucket(t *testing.T) {
+	ar, cleanup := openTestArticles(t)
+	defer cleanup()
+
+	groups := []string{"a.b"}
+	err := ar.Post(groups, "<0@test>", testArticle("<0@test>", groups))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ar.db.Update(func(tx *bolt.Tx) error {
+		grp, err := groupBucket(tx, "a.b")
+		panicIfError(err)
+		sub, err := grp.CreateBucket([]byte("nested"))
+		panicIfError(err)
+		return sub.Put([]byte("key"), []byte("value"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ar.RenameGroup("a.b", "c.d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGroup(t, ar, "c.d", groupMarks{1, 1, 1})
+	err = ar.db.View(func(tx *bolt.Tx) error {
+		grp, err := groupBucket(tx, "c.d")
+		if err != nil {
+			return err
+		} else if sub := grp.Bucket([]byte("nested")); sub == nil || string(sub.Get([]byte("key"))) != "value" {
+			t.Errorf("expected the nested bucket to be copied")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenInUse(t *testing.T) {
+	ar, cleanup := openTestArticles(t)
+	defer cleanup()
+
+	timeout := OpenTimeout
+	OpenTimeout = 10 * time.Millisecond
+	defer func() { OpenTimeout = timeout }()
+
+	other := &Articles{StorageDir: ar.StorageDir}
+	err := other.Open()
+	if err != ErrDatabaseInUse {
+		other.Close()
+		t.Errorf("expected ErrDatabaseInUse, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package articles
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/coreos/bbolt"
+)
+
+var ErrNoArticle = errors.New("No such article")
+
+// Cancel removes an article from every group it was posted to
+func (ar *Articles) Cancel(msgId string) error {
+	groups, found, err := ar.MsgIdGroups(msgId)
+	if err != nil {
+		return err
+	} else if !found {
+		return ErrNoArticle
+	}
+
+	return ar.removeFromGroups(msgId, groups, "")
+}
+
+// Supersede posts an article replacing the article target. The new article
+// takes the place of target in the threads of the groups they share, and
+// target is removed from every group it was posted to, in the same
+// transaction. If target is unknown, the article is posted as a new article.
+func (ar *Articles) Supersede(target string, groupNames []string, msgId string, data []byte) error {
+	return ar.postReader(groupNames, msgId, bytes.NewReader(data), target)
+}
+
+// removeFromGroups removes an article from groups. If replacement is set, it
+// is the Message-ID of the article taking its place in threads.
+func (ar *Articles) removeFromGroups(msgId string, groups []string, replacement string) error {
+	var unreferenced []string
+	err := ar.db.Update(func(tx *bolt.Tx) error {
+		unreferenced = removeFromGroupsTx(tx, msgId, groups, replacement)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return ar.releaseFiles(unreferenced)
+}
+
+// removeFromGroupsTx removes an article from groups within a transaction, see
+// removeFromGroups, and returns the article files to release once committed
+func removeFromGroupsTx(tx *bolt.Tx, msgId string, groups []string, replacement string) (unreferenced []string) {
+	for _, name := range groups {
+		grp, err := groupBucket(tx, name)
+		if err == ErrNoGroup {
+			continue
+		}
+
+		num, err := btoi(grp.Get(encodeStrKey(MsgIdNumPrefix, msgId)))
+		if err != nil {
+			continue
+		}
+
+		if replacement != "" {
+			if newNum, err := btoi(grp.Get(encodeStrKey(MsgIdNumPrefix, replacement))); err == nil {
+				replaceInThread(grp, msgId, replacement, newNum, time.Now())
+			}
+		}
+
+		hash, last := removeArticle(tx, grp, name, num)
+		if last {
+			unreferenced = append(unreferenced, hash)
+		}
+	}
+	return unreferenced
+}
@@ -0,0 +1,70 @@
+package articles
+
+import (
+	"time"
+
+	"github.com/coreos/bbolt"
+)
+
+// GroupControl is the change requested by a newgroup or rmgroup control
+// article
+type GroupControl struct {
+	Group string
+	// Remove the group, for rmgroup
+	Remove bool
+	// Description and moderation of the group, for newgroup. An empty
+	// description keeps the current one.
+	Description string
+	Moderated   bool
+}
+
+// ApplyGroupControl creates, changes or removes a group as requested by the
+// control article msgId. The Message-ID is recorded in the same transaction so
+// that the control article cannot be applied again: ErrDuplicateMsgId is
+// returned if it is already known. existed tells if the group existed before.
+func (ar *Articles) ApplyGroupControl(msgId string, c GroupControl) (existed bool, err error) {
+	if !ValidGroupName(c.Group) {
+		return false, ErrInvalidGroupName
+	}
+
+	var unreferenced []string
+	err = ar.db.Update(func(tx *bolt.Tx) error {
+		if msgIdKnown(tx, msgId) {
+			return ErrDuplicateMsgId
+		}
+
+		now := time.Now()
+		msgids, err := tx.CreateBucketIfNotExists([]byte("msgids"))
+		panicIfError(err)
+		panicIfError(msgids.Put(encodeStrKey(MsgIdSeenPrefix, msgId), itob(now.UnixNano())))
+
+		_, err = groupBucket(tx, c.Group)
+		existed = err == nil
+
+		if c.Remove {
+			unreferenced, err = deleteGroup(tx, c.Group)
+			return err
+		}
+
+		grp, err := createGroupBucket(tx, c.Group, now)
+		if err != nil {
+			return err
+		}
+		// Changing the moderation usually comes without a description
+		if c.Description != "" {
+			panicIfError(grp.Put(KeyGroupDescr, []byte(c.Description)))
+		}
+
+		if c.Moderated {
+			return grp.Put(KeyGroupPolicy, []byte(PolicyModerated))
+		} else if readPolicy(grp) == PolicyModerated {
+			return grp.Put(KeyGroupPolicy, []byte(PolicyPublic))
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return existed, ar.releaseFiles(unreferenced)
+}
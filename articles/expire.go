@@ -0,0 +1,245 @@
+package articles
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/bbolt"
+)
+
+var (
+	KeyGroupExpireAge   = []byte("expire-age")
+	KeyGroupExpireCount = []byte("expire-count")
+	KeyGroupExpireSize  = []byte("expire-size")
+)
+
+// Expiry tells when articles are removed from a group. Zero values disable a
+// rule. Every article older than MaxAge is removed, then articles are removed
+// oldest first until the count and size rules are satisfied.
+type Expiry struct {
+	// Maximum time since the article arrived
+	MaxAge time.Duration
+	// Maximum number of articles in the group
+	MaxCount int64
+	// Maximum total size of the articles in the group, in bytes
+	MaxSize int64
+}
+
+func (e Expiry) IsZero() bool {
+	return e.MaxAge == 0 && e.MaxCount == 0 && e.MaxSize == 0
+}
+
+func (e Expiry) String() string {
+	var rules []string
+	if e.MaxAge > 0 {
+		rules = append(rules, "age="+e.MaxAge.String())
+	}
+	if e.MaxCount > 0 {
+		rules = append(rules, fmt.Sprintf("count=%d", e.MaxCount))
+	}
+	if e.MaxSize > 0 {
+		rules = append(rules, fmt.Sprintf("size=%d", e.MaxSize))
+	}
+	return strings.Join(rules, " ")
+}
+
+// ParseExpiry parses expiry rules written as age=DURATION, count=N or
+// size=BYTES
+func ParseExpiry(rules []string) (e Expiry, err error) {
+	for _, rule := range rules {
+		kv := strings.SplitN(rule, "=", 2)
+		if len(kv) != 2 {
+			return e, fmt.Errorf("Invalid expiry rule %q", rule)
+		}
+		switch kv[0] {
+		case "age":
+			e.MaxAge, err = time.ParseDuration(kv[1])
+		case "count":
+			e.MaxCount, err = strconv.ParseInt(kv[1], 10, 64)
+		case "size":
+			e.MaxSize, err = strconv.ParseInt(kv[1], 10, 64)
+		default:
+			err = fmt.Errorf("Invalid expiry rule %q", rule)
+		}
+		if err != nil {
+			return e, err
+		}
+	}
+	return e, nil
+}
+
+// SetGroupExpiry changes the expiry rules of a group
+func (ar *Articles) SetGroupExpiry(name string, e Expiry) error {
+	return ar.db.Update(func(tx *bolt.Tx) error {
+		grp, err := groupBucket(tx, name)
+		if err != nil {
+			return err
+		}
+
+		panicIfError(grp.Put(KeyGroupExpireAge, itob(int64(e.MaxAge))))
+		panicIfError(grp.Put(KeyGroupExpireCount, itob(e.MaxCount)))
+		return grp.Put(KeyGroupExpireSize, itob(e.MaxSize))
+	})
+}
+
+func readExpiry(bucket *bolt.Bucket) (e Expiry) {
+	age, _ := btoi(bucket.Get(KeyGroupExpireAge))
+	e.MaxAge = time.Duration(age)
+	e.MaxCount, _ = btoi(bucket.Get(KeyGroupExpireCount))
+	e.MaxSize, _ = btoi(bucket.Get(KeyGroupExpireSize))
+	return e
+}
+
+// Expire removes the articles of every group according to its expiry rules
+// and returns the number of articles removed
+func (ar *Articles) Expire(now time.Time) (n int, err error) {
+	grps, err := ar.ListGroups()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, g := range grps {
+		if g.Expiry.IsZero() {
+			continue
+		}
+
+		var unreferenced []string
+		err = ar.db.Update(func(tx *bolt.Tx) error {
+			grp, err := groupBucket(tx, g.Name)
+			if err != nil {
+				return err
+			}
+
+			unreferenced = nil
+			for _, num := range ar.expiredArticles(tx, grp, g.Expiry, now) {
+				hash, last := removeArticle(tx, grp, g.Name, num)
+				if last {
+					unreferenced = append(unreferenced, hash)
+				}
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+
+		err = ar.releaseFiles(unreferenced)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// expiredArticles returns the numbers of the articles to remove from a group:
+// the articles too old, then the oldest articles over the count or size
+// limits
+func (ar *Articles) expiredArticles(tx *bolt.Tx, grp *bolt.Bucket, e Expiry, now time.Time) (res []int64) {
+	type article struct {
+		num     int64
+		size    int64
+		arrival time.Time
+	}
+
+	var articles []article
+	var count, size int64
+	msgids := tx.Bucket([]byte("msgids"))
+	eachGroupArticle(grp, func(num int64, hash, msgId string) {
+		a := article{num: num}
+
+		// Articles stored before overviews were recorded may not have one
+		var dateHeader string
+		if v := grp.Get(encodeIntKey(NumOverviewPrefix, num)); v != nil {
+			ov := decodeOverview(num, v)
+			a.size = int64(ov.Bytes)
+			dateHeader = ov.Date
+		} else if info, err := os.Stat(path.Join(ar.getPath(hash))); err == nil {
+			a.size = info.Size()
+		}
+
+		// Articles stored before arrival times were recorded use their Date
+		var arrival []byte
+		if msgids != nil {
+			arrival = msgids.Get(encodeStrKey(MsgIdArrivalPrefix, msgId))
+		}
+		if t, err := btoi(arrival); err == nil {
+			a.arrival = time.Unix(0, t)
+		} else if date, err := mail.ParseDate(dateHeader); err == nil {
+			a.arrival = date
+		}
+
+		articles = append(articles, a)
+		count++
+		size += a.size
+	})
+
+	// The age rule is checked for every article, arrival times need not
+	// follow article numbers
+	var kept []article
+	for _, a := range articles {
+		if e.MaxAge > 0 && !a.arrival.IsZero() && now.Sub(a.arrival) > e.MaxAge {
+			res = append(res, a.num)
+			count--
+			size -= a.size
+		} else {
+			kept = append(kept, a)
+		}
+	}
+
+	for _, a := range kept {
+		tooMany := e.MaxCount > 0 && count > e.MaxCount
+		tooBig := e.MaxSize > 0 && size > e.MaxSize
+		if !tooMany && !tooBig {
+			break
+		}
+		res = append(res, a.num)
+		count--
+		size -= a.size
+	}
+	return res
+}
+
+// removeArticle removes an article from a group index, updating the low
+// water mark and the article count. It returns the hash of the article file
+// and tells if it is no longer referenced, in which case the file must be
+// released once the transaction is committed.
+func removeArticle(tx *bolt.Tx, grp *bolt.Bucket, groupName string, num int64) (hash string, unreferenced bool) {
+	hash = string(grp.Get(encodeIntKey(NumFilePrefix, num)))
+	if hash == "" {
+		return "", false
+	}
+	msgId := string(grp.Get(encodeIntKey(NumMsgIdPrefix, num)))
+
+	panicIfError(grp.Delete(encodeIntKey(NumFilePrefix, num)))
+	panicIfError(grp.Delete(encodeIntKey(NumMsgIdPrefix, num)))
+	panicIfError(grp.Delete(encodeIntKey(NumOverviewPrefix, num)))
+	panicIfError(grp.Delete(encodeStrKey(MsgIdFilePrefix, msgId)))
+	panicIfError(grp.Delete(encodeStrKey(MsgIdNumPrefix, msgId)))
+	unindexThread(grp, msgId)
+	unindexSuperseded(grp, msgId)
+	renameMsgIdGroup(tx, msgId, groupName, "")
+	unreferenced = removeFileRef(tx, hash, groupName, num)
+
+	count, err := btoi(grp.Get(KeyGroupCount))
+	if err == nil && count > 0 {
+		panicIfError(grp.Put(KeyGroupCount, itob(count-1)))
+	}
+
+	// The low water mark is the first remaining article, or the next article
+	// number if the group is now empty
+	first := nextNum(grp)
+	prefix := []byte(NumFilePrefix)
+	if k, _ := grp.Cursor().Seek(prefix); k != nil && bytes.HasPrefix(k, prefix) {
+		first, _ = decodeIntKey(NumFilePrefix, k)
+	}
+	panicIfError(grp.Put(KeyGroupFirst, itob(first)))
+
+	return hash, unreferenced
+}
@@ -0,0 +1,146 @@
+package articles
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/coreos/bbolt"
+)
+
+type fileRefEntry struct {
+	hash string
+	FileRef
+}
+
+func (e fileRefEntry) String() string {
+	return fmt.Sprintf("%s from %s:%d", e.hash, e.Group, e.Num)
+}
+
+// Fsck checks that the article file references match the group indexes and
+// the stored files, and that supersede entries lead to an article, and returns
+// the problems found. With repair, references are rebuilt from the group
+// indexes, unreferenced files and dangling supersede entries are removed.
+// Missing and corrupt files can only be reported.
+func (ar *Articles) Fsck(repair bool) (problems []string, err error) {
+	if repair {
+		// Files posted during the check must not be taken for unreferenced
+		ar.filesLock.Lock()
+		defer ar.filesLock.Unlock()
+	}
+
+	var expected = map[fileRefEntry]bool{}
+	var actual = map[fileRefEntry]bool{}
+	var hashes = map[string]bool{}
+	// Supersede entries leading to a removed article, by group
+	var dangling = map[string][]string{}
+
+	err = ar.db.View(func(tx *bolt.Tx) error {
+		if groups := tx.Bucket([]byte("groups")); groups != nil {
+			panicIfError(groups.ForEach(func(name, v []byte) error {
+				if v != nil {
+					return nil
+				}
+				grp := groups.Bucket(name)
+				eachGroupArticle(grp, func(num int64, hash, msgId string) {
+					expected[fileRefEntry{hash, FileRef{string(name), num}}] = true
+					hashes[hash] = true
+				})
+
+				prefix := []byte(MsgIdSupersededPrefix)
+				cur := grp.Cursor()
+				for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+					if grp.Get(encodeStrKey(MsgIdNumPrefix, string(v))) == nil {
+						old := string(k[len(prefix):])
+						problems = append(problems, fmt.Sprintf("dangling supersede entry from %s to %s in %s", old, v, name))
+						dangling[string(name)] = append(dangling[string(name)], old)
+					}
+				}
+				return nil
+			}))
+		}
+
+		if files := tx.Bucket([]byte("files")); files != nil {
+			prefix := []byte(HashRefPrefix)
+			cur := files.Cursor()
+			for k, _ := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cur.Next() {
+				rest := k[len(prefix):]
+				sep := bytes.IndexByte(rest, ' ')
+				if sep < 0 {
+					problems = append(problems, fmt.Sprintf("invalid reference key %q", k))
+					continue
+				}
+				hash := string(rest[:sep])
+				ref, ok := decodeFileRefKey(hash, k)
+				if !ok {
+					problems = append(problems, fmt.Sprintf("invalid reference key %q", k))
+					continue
+				}
+				actual[fileRefEntry{hash, ref}] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for ref := range expected {
+		if !actual[ref] {
+			problems = append(problems, fmt.Sprintf("missing reference to %v", ref))
+		}
+	}
+	for ref := range actual {
+		if !expected[ref] {
+			problems = append(problems, fmt.Sprintf("stale reference to %v", ref))
+		}
+	}
+
+	var found = map[string]bool{}
+	err = ar.eachArticleFile(func(hash, fpath string, info os.FileInfo) error {
+		found[hash] = true
+		if !hashes[hash] {
+			problems = append(problems, fmt.Sprintf("unreferenced file %s", hash))
+			if repair {
+				return os.Remove(fpath)
+			}
+			return nil
+		}
+
+		sum, err := hashFile(fpath)
+		if err != nil {
+			return err
+		} else if sum != hash {
+			problems = append(problems, fmt.Sprintf("corrupt file %s, its content hash is %s", hash, sum))
+		}
+		return nil
+	})
+	if err != nil {
+		return problems, err
+	}
+
+	for hash := range hashes {
+		if !found[hash] {
+			problems = append(problems, fmt.Sprintf("missing file %s", hash))
+		}
+	}
+
+	if repair {
+		err = ar.db.Update(func(tx *bolt.Tx) error {
+			for name, olds := range dangling {
+				grp, err := groupBucket(tx, name)
+				if err != nil {
+					continue
+				}
+				for _, old := range olds {
+					panicIfError(grp.Delete(encodeStrKey(MsgIdSupersededPrefix, old)))
+				}
+			}
+			return rebuildFileRefs(tx)
+		})
+	}
+
+	sort.Strings(problems)
+	return problems, err
+}
@@ -0,0 +1,249 @@
+package articles
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coreos/bbolt"
+)
+
+var (
+	ErrGroupExists      = errors.New("Group already exists")
+	ErrInvalidGroupName = errors.New("Invalid group name")
+)
+
+// ValidGroupName checks a group name against RFC 5536 section 3.1.4: dot
+// separated components made of letters, digits, "+", "-" and "_", where "all"
+// and "ctl" are reserved.
+func ValidGroupName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, component := range strings.Split(name, ".") {
+		if component == "" || component == "all" || component == "ctl" {
+			return false
+		}
+		for _, c := range component {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			case c == '+', c == '-', c == '_':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ParseNewsgroups splits a Newsgroups header value into group names. Names are
+// validated and duplicates removed.
+func ParseNewsgroups(value string) ([]string, error) {
+	var res []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if !ValidGroupName(name) {
+			return nil, fmt.Errorf("%v: %q", ErrInvalidGroupName, name)
+		}
+		if !containsName(res, name) {
+			res = append(res, name)
+		}
+	}
+	return res, nil
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// GroupExists tells if the group was created
+func (ar *Articles) GroupExists(name string) (exists bool, err error) {
+	err = ar.db.View(func(tx *bolt.Tx) error {
+		groups := tx.Bucket([]byte("groups"))
+		exists = groups != nil && groups.Bucket([]byte(name)) != nil
+		return nil
+	})
+	return
+}
+
+func (ar *Articles) CreateGroup(name, descr string) error {
+	if !ValidGroupName(name) {
+		return ErrInvalidGroupName
+	}
+
+	return ar.db.Update(func(tx *bolt.Tx) error {
+		if _, err := groupBucket(tx, name); err == nil {
+			return ErrGroupExists
+		}
+
+		grp, err := createGroupBucket(tx, name, time.Now())
+		if err != nil {
+			return err
+		}
+
+		return grp.Put(KeyGroupDescr, []byte(descr))
+	})
+}
+
+func (ar *Articles) SetGroupDescription(name, descr string) error {
+	return ar.db.Update(func(tx *bolt.Tx) error {
+		grp, err := groupBucket(tx, name)
+		if err != nil {
+			return err
+		}
+
+		return grp.Put(KeyGroupDescr, []byte(descr))
+	})
+}
+
+// RenameGroup moves every entry of a group to a new name. Article files are
+// shared and left in place, only their references change.
+func (ar *Articles) RenameGroup(name, newName string) error {
+	if !ValidGroupName(newName) {
+		return ErrInvalidGroupName
+	}
+
+	return ar.db.Update(func(tx *bolt.Tx) error {
+		grp, err := groupBucket(tx, name)
+		if err != nil {
+			return err
+		}
+
+		groups := tx.Bucket([]byte("groups"))
+		newGrp, err := groups.CreateBucket([]byte(newName))
+		if err == bolt.ErrBucketExists {
+			return ErrGroupExists
+		} else if err != nil {
+			return err
+		}
+
+		panicIfError(copyBucket(newGrp, grp))
+
+		eachGroupArticle(grp, func(num int64, hash, msgId string) {
+			removeFileRef(tx, hash, name, num)
+			addFileRef(tx, hash, newName, num)
+			renameMsgIdGroup(tx, msgId, name, newName)
+		})
+
+		if created, err := btoi(grp.Get(KeyGroupCreated)); err == nil {
+			createdIdx := tx.Bucket([]byte("created"))
+			panicIfError(createdIdx.Delete(createdKey(created, name)))
+			panicIfError(createdIdx.Put(createdKey(created, newName), []byte(newName)))
+		}
+
+		return groups.DeleteBucket([]byte(name))
+	})
+}
+
+// copyBucket copies every key of src to dst, recursing into nested buckets
+func copyBucket(dst, src *bolt.Bucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+
+		sub, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(sub, src.Bucket(k))
+	})
+}
+
+// DeleteGroup removes a group and its index. Article files are removed unless
+// they are also posted to other groups.
+func (ar *Articles) DeleteGroup(name string) error {
+	var unreferenced []string
+	err := ar.db.Update(func(tx *bolt.Tx) (err error) {
+		unreferenced, err = deleteGroup(tx, name)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return ar.releaseFiles(unreferenced)
+}
+
+// deleteGroup removes a group within a transaction and returns the article
+// files to release once committed
+func deleteGroup(tx *bolt.Tx, name string) (unreferenced []string, err error) {
+	grp, err := groupBucket(tx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	eachGroupArticle(grp, func(num int64, hash, msgId string) {
+		if removeFileRef(tx, hash, name, num) {
+			unreferenced = append(unreferenced, hash)
+		}
+		renameMsgIdGroup(tx, msgId, name, "")
+	})
+
+	if created, err := btoi(grp.Get(KeyGroupCreated)); err == nil {
+		panicIfError(tx.Bucket([]byte("created")).Delete(createdKey(created, name)))
+	}
+
+	return unreferenced, tx.Bucket([]byte("groups")).DeleteBucket([]byte(name))
+}
+
+// eachGroupArticle calls fn for every article of a group
+func eachGroupArticle(grp *bolt.Bucket, fn func(num int64, hash, msgId string)) {
+	prefix := []byte(NumFilePrefix)
+	cur := grp.Cursor()
+	for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+		num, err := decodeIntKey(NumFilePrefix, k)
+		if err != nil {
+			continue
+		}
+		fn(num, string(v), string(grp.Get(encodeIntKey(NumMsgIdPrefix, num))))
+	}
+}
+
+func groupBucket(tx *bolt.Tx, name string) (*bolt.Bucket, error) {
+	groups := tx.Bucket([]byte("groups"))
+	if groups == nil {
+		return nil, ErrNoGroup
+	}
+
+	grp := groups.Bucket([]byte(name))
+	if grp == nil {
+		return nil, ErrNoGroup
+	}
+	return grp, nil
+}
+
+// createGroupBucket returns the bucket of a group. If the group does not exist
+// it is created and its creation time indexed for NEWGROUPS.
+func createGroupBucket(tx *bolt.Tx, name string, now time.Time) (*bolt.Bucket, error) {
+	groups, err := tx.CreateBucketIfNotExists([]byte("groups"))
+	panicIfError(err)
+
+	if grp := groups.Bucket([]byte(name)); grp != nil {
+		return grp, nil
+	}
+
+	grp, err := groups.CreateBucket([]byte(name))
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := tx.CreateBucketIfNotExists([]byte("created"))
+	panicIfError(err)
+
+	panicIfError(grp.Put(KeyGroupCreated, itob(now.UnixNano())))
+	panicIfError(created.Put(createdKey(now.UnixNano(), name), []byte(name)))
+	return grp, nil
+}
+
+// createdKey is the key of the created bucket, ordered by creation time
+func createdKey(created int64, name string) []byte {
+	return append(itob(created), []byte(name)...)
+}
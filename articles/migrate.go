@@ -0,0 +1,105 @@
+package articles
+
+import (
+	"bytes"
+	"log"
+
+	"github.com/coreos/bbolt"
+)
+
+// DbVersion is the version of the index.db layout
+const DbVersion = 4
+
+var KeyDbVersion = []byte("version")
+
+// migrate upgrades the index.db layout to DbVersion
+func (ar *Articles) migrate() error {
+	return ar.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte("meta"))
+		panicIfError(err)
+
+		version, err := btoi(meta.Get(KeyDbVersion))
+		if err != nil {
+			version = 0
+		}
+
+		if version < 1 {
+			err = migrateNumbering(tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		if version < 2 {
+			err = migrateMsgIds(tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		if version < 3 {
+			err = migrateMsgIdGroups(tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		if version < 4 {
+			err = rebuildFileRefs(tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		return meta.Put(KeyDbVersion, itob(DbVersion))
+	})
+}
+
+// migrateNumbering repairs groups created before the next-num counter. Older
+// versions stored one past the highest article number as the high mark.
+func migrateNumbering(tx *bolt.Tx) error {
+	groups := tx.Bucket([]byte("groups"))
+	if groups == nil {
+		return nil
+	}
+
+	return groups.ForEach(func(name, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		grp := groups.Bucket(name)
+
+		var low, high, count int64
+		prefix := []byte(NumFilePrefix)
+		cur := grp.Cursor()
+		for k, _ := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cur.Next() {
+			num, err := decodeIntKey(NumFilePrefix, k)
+			if err != nil {
+				return err
+			}
+			if count == 0 || num < low {
+				low = num
+			}
+			if num > high {
+				high = num
+			}
+			count++
+		}
+
+		next := high + 1
+		if oldLast, err := btoi(grp.Get(KeyGroupLast)); err == nil && oldLast > next {
+			next = oldLast
+		}
+		if count == 0 {
+			low = next
+			high = next - 1
+		}
+
+		log.Printf("INFO: Migrate group %s numbering: low %d high %d count %d next %d", name, low, high, count, next)
+		panicIfError(grp.Put(KeyGroupFirst, itob(low)))
+		panicIfError(grp.Put(KeyGroupLast, itob(high)))
+		panicIfError(grp.Put(KeyGroupCount, itob(count)))
+		panicIfError(grp.Put(KeyGroupNextNum, itob(next)))
+		return nil
+	})
+}
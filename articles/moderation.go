@@ -0,0 +1,151 @@
+package articles
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/coreos/bbolt"
+)
+
+// Moderation is an article posted to a moderated group, waiting for a
+// moderator to approve or reject it. The embedded Pending token identifies the
+// moderation request.
+type Moderation struct {
+	Pending
+	ApproveToken string
+	RejectToken  string
+}
+
+var ErrNoModeration = errors.New("No such article waiting for moderation")
+
+var (
+	KeyModerationApprove = []byte("approve")
+	KeyModerationReject  = []byte("reject")
+)
+
+const moderatorSep = " "
+
+// AddModeration queues an article until a moderator approves or rejects it
+func (ar *Articles) AddModeration(m *Moderation) error {
+	return ar.db.Update(func(tx *bolt.Tx) error {
+		moderation, err := tx.CreateBucketIfNotExists([]byte("moderation"))
+		panicIfError(err)
+		tokens, err := tx.CreateBucketIfNotExists([]byte("moderation-tokens"))
+		panicIfError(err)
+
+		bucket, err := moderation.CreateBucket([]byte(m.Token))
+		if err != nil {
+			return err
+		}
+
+		writePending(bucket, &m.Pending)
+		panicIfError(bucket.Put(KeyModerationApprove, []byte(m.ApproveToken)))
+		panicIfError(bucket.Put(KeyModerationReject, []byte(m.RejectToken)))
+		panicIfError(tokens.Put([]byte(m.ApproveToken), []byte(m.Token)))
+		panicIfError(tokens.Put([]byte(m.RejectToken), []byte(m.Token)))
+		return nil
+	})
+}
+
+// GetModerationByToken finds the moderation request for an approve or reject
+// token.
+func (ar *Articles) GetModerationByToken(token string) (m *Moderation, err error) {
+	err = ar.db.View(func(tx *bolt.Tx) error {
+		moderation := tx.Bucket([]byte("moderation"))
+		tokens := tx.Bucket([]byte("moderation-tokens"))
+		if moderation == nil || tokens == nil {
+			return ErrNoModeration
+		}
+
+		id := tokens.Get([]byte(token))
+		if id == nil {
+			return ErrNoModeration
+		}
+
+		bucket := moderation.Bucket(id)
+		if bucket == nil {
+			return ErrNoModeration
+		}
+
+		m = &Moderation{}
+		m.Token = string(id)
+		readPending(bucket, &m.Pending)
+		m.ApproveToken = string(bucket.Get(KeyModerationApprove))
+		m.RejectToken = string(bucket.Get(KeyModerationReject))
+		return nil
+	})
+	return
+}
+
+func (ar *Articles) RemoveModeration(id string) error {
+	return ar.db.Update(func(tx *bolt.Tx) error {
+		moderation := tx.Bucket([]byte("moderation"))
+		if moderation == nil || moderation.Bucket([]byte(id)) == nil {
+			return ErrNoModeration
+		}
+
+		removeModeration(tx, moderation, []byte(id))
+		return nil
+	})
+}
+
+func removeModeration(tx *bolt.Tx, moderation *bolt.Bucket, id []byte) {
+	bucket := moderation.Bucket(id)
+	if tokens := tx.Bucket([]byte("moderation-tokens")); tokens != nil {
+		panicIfError(tokens.Delete(bucket.Get(KeyModerationApprove)))
+		panicIfError(tokens.Delete(bucket.Get(KeyModerationReject)))
+	}
+	panicIfError(moderation.DeleteBucket(id))
+}
+
+// CleanExpiredModeration removes every moderation request that expired before
+// now and returns the number of requests removed.
+func (ar *Articles) CleanExpiredModeration(now time.Time) (n int, err error) {
+	err = ar.db.Update(func(tx *bolt.Tx) error {
+		moderation := tx.Bucket([]byte("moderation"))
+		if moderation == nil {
+			return nil
+		}
+
+		var expired [][]byte
+		cur := moderation.Cursor()
+		for k, v := cur.First(); k != nil; k, v = cur.Next() {
+			if v != nil {
+				continue
+			}
+			expire, err := decodeTime(moderation.Bucket(k).Get(KeyPendingExpire))
+			if err != nil || expire.Before(now) {
+				expired = append(expired, k)
+			}
+		}
+
+		for _, k := range expired {
+			removeModeration(tx, moderation, k)
+		}
+		n = len(expired)
+		return nil
+	})
+	return
+}
+
+// SetGroupModerators changes the list of moderator e-mail addresses of a
+// group, creating the group if needed.
+func (ar *Articles) SetGroupModerators(name string, moderators []string) error {
+	if !ValidGroupName(name) {
+		return ErrInvalidGroupName
+	}
+
+	return ar.db.Update(func(tx *bolt.Tx) error {
+		grp, err := createGroupBucket(tx, name, time.Now())
+		if err != nil {
+			return err
+		}
+
+		return grp.Put(KeyGroupModerators, []byte(strings.Join(moderators, moderatorSep)))
+	})
+}
+
+func readModerators(bucket *bolt.Bucket) []string {
+	return strings.Fields(string(bucket.Get(KeyGroupModerators)))
+}
@@ -0,0 +1,258 @@
+package articles
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/coreos/bbolt"
+)
+
+// The msgids bucket indexes articles across groups
+const (
+	MsgIdGroupsPrefix    = "msgid-groups."    // message-id to group names
+	MsgIdArrivalPrefix   = "msgid-arrival."   // message-id to arrival time
+	ArrivalMsgIdPrefix   = "arrival-msgid."   // arrival time and message-id to message-id
+	MsgIdSeenPrefix      = "msgid-seen."      // message-id of an applied control article or a removed article to the time it was seen
+	ForgottenMsgIdPrefix = "forgotten-msgid." // removal time and message-id of a removed article to message-id
+)
+
+// DefaultMsgIdHistory is how long the Message-ID of a removed article is
+// remembered, and refused
+const DefaultMsgIdHistory = 30 * 24 * time.Hour
+
+const groupsSep = " "
+
+var ErrDuplicateMsgId = errors.New("Duplicate Message-ID")
+
+// HasMsgId tells if an article with this Message-ID was posted to any group,
+// or was a control article already applied
+func (ar *Articles) HasMsgId(msgId string) (found bool, err error) {
+	err = ar.db.View(func(tx *bolt.Tx) error {
+		found = msgIdKnown(tx, msgId)
+		return nil
+	})
+	return
+}
+
+// msgIdKnown tells if a Message-ID was posted or seen, it cannot be used again
+func msgIdKnown(tx *bolt.Tx, msgId string) bool {
+	msgids := tx.Bucket([]byte("msgids"))
+	if msgids == nil {
+		return false
+	}
+	return msgids.Get(encodeStrKey(MsgIdFilePrefix, msgId)) != nil ||
+		msgids.Get(encodeStrKey(MsgIdSeenPrefix, msgId)) != nil
+}
+
+// MsgIdGroups returns the groups an article was posted to. found is false if
+// the Message-ID is unknown.
+func (ar *Articles) MsgIdGroups(msgId string) (groups []string, found bool, err error) {
+	err = ar.db.View(func(tx *bolt.Tx) error {
+		groups, found = msgIdGroups(tx, msgId)
+		return nil
+	})
+	return
+}
+
+func msgIdGroups(tx *bolt.Tx, msgId string) (groups []string, found bool) {
+	msgids := tx.Bucket([]byte("msgids"))
+	if msgids == nil {
+		return nil, false
+	}
+
+	found = msgids.Get(encodeStrKey(MsgIdFilePrefix, msgId)) != nil
+	if names := msgids.Get(encodeStrKey(MsgIdGroupsPrefix, msgId)); len(names) > 0 {
+		groups = strings.Split(string(names), groupsSep)
+	}
+	return groups, found
+}
+
+// GetArticle opens an article from any group, or returns nil if the
+// Message-ID is unknown
+func (ar *Articles) GetArticle(msgId string) (io.ReadCloser, error) {
+	var hash string
+	err := ar.db.View(func(tx *bolt.Tx) error {
+		if msgids := tx.Bucket([]byte("msgids")); msgids != nil {
+			hash = string(msgids.Get(encodeStrKey(MsgIdFilePrefix, msgId)))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ar.getArticleFromHash("", hash)
+}
+
+// indexMsgId records the Message-ID in the global index, it fails if the
+// Message-ID is already known.
+func indexMsgId(tx *bolt.Tx, msgId, hash string, groupNames []string, now time.Time) error {
+	msgids, err := tx.CreateBucketIfNotExists([]byte("msgids"))
+	panicIfError(err)
+
+	if msgIdKnown(tx, msgId) {
+		return ErrDuplicateMsgId
+	}
+	panicIfError(msgids.Put(encodeStrKey(MsgIdGroupsPrefix, msgId), []byte(strings.Join(groupNames, groupsSep))))
+	panicIfError(msgids.Put(encodeStrKey(MsgIdArrivalPrefix, msgId), itob(now.UnixNano())))
+	panicIfError(msgids.Put(arrivalMsgIdKey(now.UnixNano(), msgId), []byte(msgId)))
+	return msgids.Put(encodeStrKey(MsgIdFilePrefix, msgId), []byte(hash))
+}
+
+// renameMsgIdGroup renames a group in the list of groups of an article, or
+// removes it if to is empty. The article is forgotten once it is in no group,
+// its Message-ID is then marked as seen so that it cannot be posted again
+// until CleanForgottenMsgIds removes the mark.
+func renameMsgIdGroup(tx *bolt.Tx, msgId, from, to string) {
+	msgids := tx.Bucket([]byte("msgids"))
+	if msgids == nil || msgId == "" {
+		return
+	}
+
+	var names []string
+	for _, name := range strings.Split(string(msgids.Get(encodeStrKey(MsgIdGroupsPrefix, msgId))), groupsSep) {
+		if name == from {
+			name = to
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) > 0 {
+		panicIfError(msgids.Put(encodeStrKey(MsgIdGroupsPrefix, msgId), []byte(strings.Join(names, groupsSep))))
+		return
+	}
+
+	if arrival, err := btoi(msgids.Get(encodeStrKey(MsgIdArrivalPrefix, msgId))); err == nil {
+		panicIfError(msgids.Delete(arrivalMsgIdKey(arrival, msgId)))
+	}
+	panicIfError(msgids.Delete(encodeStrKey(MsgIdArrivalPrefix, msgId)))
+	panicIfError(msgids.Delete(encodeStrKey(MsgIdGroupsPrefix, msgId)))
+	panicIfError(msgids.Delete(encodeStrKey(MsgIdFilePrefix, msgId)))
+
+	now := time.Now().UnixNano()
+	panicIfError(msgids.Put(encodeStrKey(MsgIdSeenPrefix, msgId), itob(now)))
+	panicIfError(msgids.Put(forgottenMsgIdKey(now, msgId), []byte(msgId)))
+}
+
+func forgottenMsgIdKey(forgotten int64, msgId string) []byte {
+	return append(encodeIntKey(ForgottenMsgIdPrefix, forgotten), []byte(msgId)...)
+}
+
+// CleanForgottenMsgIds removes the seen marks of the articles removed before
+// now minus MsgIdHistory, their Message-ID can then be posted again. The marks
+// of applied control articles are kept. It returns the number of marks
+// removed.
+func (ar *Articles) CleanForgottenMsgIds(now time.Time) (n int, err error) {
+	history := ar.MsgIdHistory
+	if history <= 0 {
+		history = DefaultMsgIdHistory
+	}
+	before := now.Add(-history).UnixNano()
+
+	err = ar.db.Update(func(tx *bolt.Tx) error {
+		msgids := tx.Bucket([]byte("msgids"))
+		if msgids == nil {
+			return nil
+		}
+
+		var keys [][]byte
+		prefix := []byte(ForgottenMsgIdPrefix)
+		cur := msgids.Cursor()
+		for k, _ := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cur.Next() {
+			forgotten, err := decodeIntKey(ForgottenMsgIdPrefix, k)
+			if err != nil || forgotten >= before {
+				break
+			}
+			keys = append(keys, append([]byte(nil), k...))
+		}
+
+		for _, k := range keys {
+			msgId := string(msgids.Get(k))
+			panicIfError(msgids.Delete(k))
+			// Not posted again since, the mark is the one of this removal
+			if msgids.Get(encodeStrKey(MsgIdFilePrefix, msgId)) == nil {
+				panicIfError(msgids.Delete(encodeStrKey(MsgIdSeenPrefix, msgId)))
+			}
+		}
+		n = len(keys)
+		return nil
+	})
+	return
+}
+
+func arrivalMsgIdKey(arrival int64, msgId string) []byte {
+	return append(encodeIntKey(ArrivalMsgIdPrefix, arrival), []byte(msgId)...)
+}
+
+// migrateMsgIds builds the global Message-ID index from the group indexes
+func migrateMsgIds(tx *bolt.Tx) error {
+	groups := tx.Bucket([]byte("groups"))
+	if groups == nil {
+		return nil
+	}
+
+	msgids, err := tx.CreateBucketIfNotExists([]byte("msgids"))
+	panicIfError(err)
+
+	return groups.ForEach(func(name, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		grp := groups.Bucket(name)
+
+		prefix := []byte(MsgIdFilePrefix)
+		cur := grp.Cursor()
+		for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+			if len(k) == len(prefix) {
+				// Articles without Message-ID were all indexed under the empty
+				// string
+				continue
+			}
+			panicIfError(msgids.Put(k, v))
+		}
+		return nil
+	})
+}
+
+// migrateMsgIdGroups records in the global Message-ID index the groups of
+// every article
+func migrateMsgIdGroups(tx *bolt.Tx) error {
+	groups := tx.Bucket([]byte("groups"))
+	if groups == nil {
+		return nil
+	}
+
+	msgids, err := tx.CreateBucketIfNotExists([]byte("msgids"))
+	panicIfError(err)
+
+	return groups.ForEach(func(name, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		grp := groups.Bucket(name)
+
+		prefix := []byte(MsgIdNumPrefix)
+		cur := grp.Cursor()
+		for k, _ := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cur.Next() {
+			msgId, err := decodeStrKey(MsgIdNumPrefix, k)
+			if err != nil {
+				return err
+			} else if msgId == "" {
+				continue
+			}
+
+			key := encodeStrKey(MsgIdGroupsPrefix, msgId)
+			names := string(msgids.Get(key))
+			if names != "" {
+				names += groupsSep
+			}
+			panicIfError(msgids.Put(key, []byte(names+string(name))))
+		}
+		return nil
+	})
+}
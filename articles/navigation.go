@@ -0,0 +1,107 @@
+package articles
+
+import (
+	"bytes"
+
+	"github.com/coreos/bbolt"
+)
+
+// ArticleMsgId returns the Message-ID of an article without opening its file.
+// found is false if there is no article with this number.
+func (ar *Articles) ArticleMsgId(groupName string, num int64) (msgId string, found bool, err error) {
+	err = ar.db.View(func(tx *bolt.Tx) error {
+		grp, err := groupBucket(tx, groupName)
+		if err != nil {
+			return err
+		}
+
+		found = grp.Get(encodeIntKey(NumFilePrefix, num)) != nil
+		msgId = string(grp.Get(encodeIntKey(NumMsgIdPrefix, num)))
+		return nil
+	})
+	return
+}
+
+// ArticleNum returns the number of an article in a group, or 0 if the article
+// was not posted to this group.
+func (ar *Articles) ArticleNum(groupName string, msgId string) (num int64, err error) {
+	err = ar.db.View(func(tx *bolt.Tx) error {
+		grp, err := groupBucket(tx, groupName)
+		if err != nil {
+			return err
+		}
+
+		if v := grp.Get(encodeStrKey(MsgIdNumPrefix, msgId)); v != nil {
+			num, err = btoi(v)
+		}
+		return err
+	})
+	return
+}
+
+// ListNums returns the numbers of the articles in from..to, skipping removed
+// articles. A negative to means no upper bound.
+func (ar *Articles) ListNums(groupName string, from, to int64) (res []int64, err error) {
+	err = ar.db.View(func(tx *bolt.Tx) error {
+		grp, err := groupBucket(tx, groupName)
+		if err != nil {
+			return err
+		}
+
+		prefix := []byte(NumFilePrefix)
+		cur := grp.Cursor()
+		for k, _ := cur.Seek(encodeIntKey(NumFilePrefix, from)); k != nil && bytes.HasPrefix(k, prefix); k, _ = cur.Next() {
+			num, err := decodeIntKey(NumFilePrefix, k)
+			if err != nil {
+				return err
+			} else if to >= 0 && num > to {
+				break
+			}
+			res = append(res, num)
+		}
+		return nil
+	})
+	return
+}
+
+// ArticleAfter returns the first article numbered after num, as NEXT does. num
+// is 0 if there is no such article.
+func (ar *Articles) ArticleAfter(groupName string, num int64) (int64, string, error) {
+	return ar.seekArticle(groupName, func(cur *bolt.Cursor) ([]byte, []byte) {
+		return cur.Seek(encodeIntKey(NumFilePrefix, num+1))
+	})
+}
+
+// ArticleBefore returns the last article numbered before num, as LAST does. num
+// is 0 if there is no such article.
+func (ar *Articles) ArticleBefore(groupName string, num int64) (int64, string, error) {
+	return ar.seekArticle(groupName, func(cur *bolt.Cursor) ([]byte, []byte) {
+		k, _ := cur.Seek(encodeIntKey(NumFilePrefix, num))
+		if k == nil {
+			return cur.Last()
+		}
+		return cur.Prev()
+	})
+}
+
+func (ar *Articles) seekArticle(groupName string, seek func(cur *bolt.Cursor) ([]byte, []byte)) (num int64, msgId string, err error) {
+	err = ar.db.View(func(tx *bolt.Tx) error {
+		grp, err := groupBucket(tx, groupName)
+		if err != nil {
+			return err
+		}
+
+		k, _ := seek(grp.Cursor())
+		if k == nil || !bytes.HasPrefix(k, []byte(NumFilePrefix)) {
+			return nil
+		}
+
+		num, err = decodeIntKey(NumFilePrefix, k)
+		if err != nil {
+			return err
+		}
+		msgId = string(grp.Get(encodeIntKey(NumMsgIdPrefix, num)))
+		return nil
+	})
+	return
+}
@@ -0,0 +1,57 @@
+package articles
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/coreos/bbolt"
+)
+
+// NewGroups returns the groups created since the given time, for NEWGROUPS
+func (ar *Articles) NewGroups(since time.Time) (res []*Group, err error) {
+	err = ar.db.View(func(tx *bolt.Tx) error {
+		created := tx.Bucket([]byte("created"))
+		if created == nil {
+			return nil
+		}
+
+		cur := created.Cursor()
+		for k, v := cur.Seek(itob(since.UnixNano())); k != nil; k, v = cur.Next() {
+			grp, err := groupBucket(tx, string(v))
+			if err != nil {
+				continue
+			}
+			var group = &Group{Name: string(v)}
+			readGroup(grp, group)
+			res = append(res, group)
+		}
+		return nil
+	})
+	return
+}
+
+// NewNews returns the Message-IDs of the articles that arrived since the given
+// time in any of the groups, for NEWNEWS
+func (ar *Articles) NewNews(since time.Time, groupNames []string) (res []string, err error) {
+	err = ar.db.View(func(tx *bolt.Tx) error {
+		msgids := tx.Bucket([]byte("msgids"))
+		if msgids == nil {
+			return nil
+		}
+
+		prefix := []byte(ArrivalMsgIdPrefix)
+		cur := msgids.Cursor()
+		for k, v := cur.Seek(encodeIntKey(ArrivalMsgIdPrefix, since.UnixNano())); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+			msgId := string(v)
+			for _, name := range strings.Split(string(msgids.Get(encodeStrKey(MsgIdGroupsPrefix, msgId))), groupsSep) {
+				if containsName(groupNames, name) {
+					res = append(res, msgId)
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,197 @@
+package articles
+
+import (
+	"bufio"
+	"bytes"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/bbolt"
+)
+
+const NumOverviewPrefix = "num-overview." // article number to overview
+
+// Overview contains the fields returned by OVER/XOVER for an article
+type Overview struct {
+	Num        int64
+	Subject    string
+	From       string
+	Date       string
+	MsgId      string
+	References string
+	Bytes      int
+	Lines      int
+}
+
+// OverviewFields lists the overview fields in the order of LIST OVERVIEW.FMT
+var OverviewFields = []string{"Subject:", "From:", "Date:", "Message-ID:", "References:", ":bytes", ":lines"}
+
+const overviewSep = "\t"
+
+func readHeader(data []byte) textproto.MIMEHeader {
+	header, _ := textproto.NewReader(bufio.NewReader(bytes.NewReader(data))).ReadMIMEHeader()
+	return header
+}
+
+func overviewValue(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ", "\t", " ").Replace(value)
+}
+
+func readOverview(header textproto.MIMEHeader, size, lines int) *Overview {
+	return &Overview{
+		Subject:    overviewValue(header.Get("Subject")),
+		From:       overviewValue(header.Get("From")),
+		Date:       overviewValue(header.Get("Date")),
+		MsgId:      overviewValue(header.Get("Message-Id")),
+		References: overviewValue(header.Get("References")),
+		Bytes:      size,
+		Lines:      lines,
+	}
+}
+
+// bodyLines counts the lines in the body of a raw article
+func bodyLines(data []byte) int {
+	if i := bytes.Index(data, []byte("\n\n")); i >= 0 {
+		return bytes.Count(data[i+2:], []byte("\n"))
+	} else if i := bytes.Index(data, []byte("\r\n\r\n")); i >= 0 {
+		return bytes.Count(data[i+4:], []byte("\n"))
+	}
+	return 0
+}
+
+func (ov *Overview) encode() []byte {
+	return []byte(strings.Join([]string{
+		ov.Subject,
+		ov.From,
+		ov.Date,
+		ov.MsgId,
+		ov.References,
+		strconv.Itoa(ov.Bytes),
+		strconv.Itoa(ov.Lines),
+	}, overviewSep))
+}
+
+func decodeOverview(num int64, data []byte) *Overview {
+	fields := strings.Split(string(data), overviewSep)
+	for len(fields) < 7 {
+		fields = append(fields, "")
+	}
+	ov := &Overview{
+		Num:        num,
+		Subject:    fields[0],
+		From:       fields[1],
+		Date:       fields[2],
+		MsgId:      fields[3],
+		References: fields[4],
+	}
+	ov.Bytes, _ = strconv.Atoi(fields[5])
+	ov.Lines, _ = strconv.Atoi(fields[6])
+	return ov
+}
+
+// GetOverview returns the overview of the articles numbered from..to
+func (ar *Articles) GetOverview(groupName string, from, to int64) (res []*Overview, err error) {
+	err = ar.db.View(func(tx *bolt.Tx) error {
+		grp, err := groupBucket(tx, groupName)
+		if err != nil {
+			return err
+		}
+
+		prefix := []byte(NumOverviewPrefix)
+		cur := grp.Cursor()
+		for k, v := cur.Seek(encodeIntKey(NumOverviewPrefix, from)); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+			num, err := decodeIntKey(NumOverviewPrefix, k)
+			if err != nil {
+				return err
+			} else if num > to {
+				break
+			}
+			res = append(res, decodeOverview(num, v))
+		}
+		return nil
+	})
+	return
+}
+
+// ReindexBatch is the number of overviews Reindex writes per transaction
+const ReindexBatch = 100
+
+// Reindex rebuilds the overview of every article from the article files. The
+// article files of a group are listed first, then read outside of any
+// transaction, and their overviews written in batches of ReindexBatch.
+func (ar *Articles) Reindex() (n int, err error) {
+	grps, err := ar.ListGroups()
+	if err != nil {
+		return 0, err
+	}
+
+	type article struct {
+		num      int64
+		hash     string
+		overview []byte
+	}
+
+	for _, g := range grps {
+		var articles []*article
+		err = ar.db.View(func(tx *bolt.Tx) error {
+			grp, err := groupBucket(tx, g.Name)
+			if err != nil {
+				return err
+			}
+
+			eachGroupArticle(grp, func(num int64, hash, msgId string) {
+				articles = append(articles, &article{num: num, hash: hash})
+			})
+			return nil
+		})
+		if err == ErrNoGroup {
+			continue
+		} else if err != nil {
+			return n, err
+		}
+
+		for len(articles) > 0 {
+			batch := articles
+			if len(batch) > ReindexBatch {
+				batch = batch[:ReindexBatch]
+			}
+			articles = articles[len(batch):]
+
+			for _, a := range batch {
+				data, err := ar.readArticleFile(a.hash)
+				if os.IsNotExist(err) {
+					// Removed since listed
+					continue
+				} else if err != nil {
+					return n, err
+				}
+				a.overview = readOverview(readHeader(data), len(data), bodyLines(data)).encode()
+			}
+
+			err = ar.db.Update(func(tx *bolt.Tx) error {
+				grp, err := groupBucket(tx, g.Name)
+				if err != nil {
+					return err
+				}
+
+				for _, a := range batch {
+					// Skip the articles removed while reading the files
+					if a.overview == nil || string(grp.Get(encodeIntKey(NumFilePrefix, a.num))) != a.hash {
+						continue
+					}
+					panicIfError(grp.Put(encodeIntKey(NumOverviewPrefix, a.num), a.overview))
+					n++
+				}
+				return nil
+			})
+			if err == ErrNoGroup {
+				break
+			} else if err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
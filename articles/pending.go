@@ -0,0 +1,145 @@
+package articles
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/coreos/bbolt"
+)
+
+// Pending is an article waiting for the e-mail address of its sender to be
+// validated before it can be published.
+type Pending struct {
+	Token  string
+	Email  string
+	Groups []string
+	MsgId  string
+	Data   []byte
+	Expire time.Time
+}
+
+var ErrNoPending = errors.New("No such pending article")
+
+var (
+	KeyPendingEmail  = []byte("email")
+	KeyPendingGroups = []byte("groups")
+	KeyPendingMsgId  = []byte("msgid")
+	KeyPendingData   = []byte("data")
+	KeyPendingExpire = []byte("expire")
+)
+
+const pendingGroupSep = "\n"
+
+// AddPending stores an article under its validation token until the token
+// comes back or the article expires.
+func (ar *Articles) AddPending(p *Pending) error {
+	return ar.db.Update(func(tx *bolt.Tx) error {
+		pending, err := tx.CreateBucketIfNotExists([]byte("pending"))
+		panicIfError(err)
+
+		bucket, err := pending.CreateBucket([]byte(p.Token))
+		if err != nil {
+			return err
+		}
+
+		writePending(bucket, p)
+		return nil
+	})
+}
+
+func writePending(bucket *bolt.Bucket, p *Pending) {
+	panicIfError(bucket.Put(KeyPendingEmail, []byte(p.Email)))
+	panicIfError(bucket.Put(KeyPendingGroups, []byte(strings.Join(p.Groups, pendingGroupSep))))
+	panicIfError(bucket.Put(KeyPendingMsgId, []byte(p.MsgId)))
+	panicIfError(bucket.Put(KeyPendingData, p.Data))
+	panicIfError(bucket.Put(KeyPendingExpire, encodeTime(p.Expire)))
+}
+
+func readPending(bucket *bolt.Bucket, p *Pending) {
+	p.Email = string(bucket.Get(KeyPendingEmail))
+	p.Groups = strings.Split(string(bucket.Get(KeyPendingGroups)), pendingGroupSep)
+	p.MsgId = string(bucket.Get(KeyPendingMsgId))
+	p.Data = append([]byte(nil), bucket.Get(KeyPendingData)...)
+	p.Expire, _ = decodeTime(bucket.Get(KeyPendingExpire))
+}
+
+func (ar *Articles) GetPending(token string) (p *Pending, err error) {
+	err = ar.db.View(func(tx *bolt.Tx) error {
+		pending := tx.Bucket([]byte("pending"))
+		if pending == nil {
+			return ErrNoPending
+		}
+
+		bucket := pending.Bucket([]byte(token))
+		if bucket == nil {
+			return ErrNoPending
+		}
+
+		p = &Pending{Token: token}
+		readPending(bucket, p)
+		return nil
+	})
+	return
+}
+
+func (ar *Articles) RemovePending(token string) error {
+	return ar.db.Update(func(tx *bolt.Tx) error {
+		pending := tx.Bucket([]byte("pending"))
+		if pending == nil || pending.Bucket([]byte(token)) == nil {
+			return ErrNoPending
+		}
+		return pending.DeleteBucket([]byte(token))
+	})
+}
+
+// RemovePendingTokens removes the pending articles stored under any of the
+// tokens and returns the number of articles removed.
+func (ar *Articles) RemovePendingTokens(tokens []string) (n int, err error) {
+	err = ar.db.Update(func(tx *bolt.Tx) error {
+		pending := tx.Bucket([]byte("pending"))
+		if pending == nil {
+			return nil
+		}
+
+		for _, token := range tokens {
+			if pending.Bucket([]byte(token)) == nil {
+				continue
+			}
+			panicIfError(pending.DeleteBucket([]byte(token)))
+			n++
+		}
+		return nil
+	})
+	return
+}
+
+// CleanExpiredPending removes every pending article that expired before now
+// and returns the number of articles removed.
+func (ar *Articles) CleanExpiredPending(now time.Time) (n int, err error) {
+	err = ar.db.Update(func(tx *bolt.Tx) error {
+		pending := tx.Bucket([]byte("pending"))
+		if pending == nil {
+			return nil
+		}
+
+		var expired [][]byte
+		cur := pending.Cursor()
+		for k, v := cur.First(); k != nil; k, v = cur.Next() {
+			if v != nil {
+				continue
+			}
+			expire, err := decodeTime(pending.Bucket(k).Get(KeyPendingExpire))
+			if err != nil || expire.Before(now) {
+				expired = append(expired, k)
+			}
+		}
+
+		for _, k := range expired {
+			panicIfError(pending.DeleteBucket(k))
+		}
+		n = len(expired)
+		return nil
+	})
+	return
+}
@@ -0,0 +1,81 @@
+package articles
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/coreos/bbolt"
+)
+
+// Policy controls who can read and post to a group
+type Policy string
+
+const (
+	PolicyPublic         Policy = "public"                 // anyone can read and post
+	PolicyReadOnly       Policy = "read-only"              // anyone can read, nobody can post
+	PolicyMembersOnly    Policy = "members-only-read"      // only authenticated users can read and post
+	PolicyValidatedPosts Policy = "validated-posters-only" // anyone can read, only validated addresses can post
+	PolicyModerated      Policy = "moderated"              // anyone can read, posts are approved by moderators
+)
+
+var Policies = []Policy{
+	PolicyPublic,
+	PolicyReadOnly,
+	PolicyMembersOnly,
+	PolicyValidatedPosts,
+	PolicyModerated,
+}
+
+func ParsePolicy(s string) (Policy, error) {
+	for _, p := range Policies {
+		if string(p) == s {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown group policy %s", s)
+}
+
+// GroupPolicy returns the policy of a group. Groups that do not exist yet are
+// public.
+func (ar *Articles) GroupPolicy(name string) (policy Policy, err error) {
+	policy = PolicyPublic
+	err = ar.db.View(func(tx *bolt.Tx) error {
+		groups := tx.Bucket([]byte("groups"))
+		if groups == nil {
+			return nil
+		}
+
+		grp := groups.Bucket([]byte(name))
+		if grp == nil {
+			return nil
+		}
+
+		policy = readPolicy(grp)
+		return nil
+	})
+	return
+}
+
+// SetGroupPolicy changes the policy of a group, creating the group if needed
+func (ar *Articles) SetGroupPolicy(name string, policy Policy) error {
+	if !ValidGroupName(name) {
+		return ErrInvalidGroupName
+	}
+
+	return ar.db.Update(func(tx *bolt.Tx) error {
+		grp, err := createGroupBucket(tx, name, time.Now())
+		if err != nil {
+			return err
+		}
+
+		return grp.Put(KeyGroupPolicy, []byte(policy))
+	})
+}
+
+func readPolicy(bucket *bolt.Bucket) Policy {
+	policy := Policy(bucket.Get(KeyGroupPolicy))
+	if policy == "" {
+		return PolicyPublic
+	}
+	return policy
+}
@@ -0,0 +1,155 @@
+package articles
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path"
+
+	"github.com/coreos/bbolt"
+)
+
+// The files bucket references each article file from the group articles
+// using it. A file is removed once its last reference is gone.
+//
+// Files are shared by the groups of a cross-post. A file includes the Xref
+// header with the article numbers, so a re-post, even of the same content, is
+// stored as a new file; re-posting a Message-ID is refused anyway.
+const HashRefPrefix = "hash-ref." // hash, group and article number, no value
+
+// FileRef is a reference to an article file
+type FileRef struct {
+	Group string
+	Num   int64
+}
+
+func fileRefPrefix(hash string) []byte {
+	return encodeStrKey(HashRefPrefix, hash+" ")
+}
+
+func fileRefKey(hash, groupName string, num int64) []byte {
+	return append(fileRefPrefix(hash), append([]byte(groupName+" "), itob(num)...)...)
+}
+
+func decodeFileRefKey(hash string, key []byte) (ref FileRef, ok bool) {
+	rest := key[len(fileRefPrefix(hash)):]
+	if len(rest) < 9 {
+		return ref, false
+	}
+	num, err := btoi(rest[len(rest)-8:])
+	if err != nil {
+		return ref, false
+	}
+	return FileRef{Group: string(rest[:len(rest)-9]), Num: num}, true
+}
+
+func addFileRef(tx *bolt.Tx, hash, groupName string, num int64) {
+	files, err := tx.CreateBucketIfNotExists([]byte("files"))
+	panicIfError(err)
+	panicIfError(files.Put(fileRefKey(hash, groupName, num), []byte{}))
+}
+
+// removeFileRef removes a reference to an article file and tells if the file
+// is no longer referenced
+func removeFileRef(tx *bolt.Tx, hash, groupName string, num int64) (unreferenced bool) {
+	files := tx.Bucket([]byte("files"))
+	if files == nil {
+		return true
+	}
+	panicIfError(files.Delete(fileRefKey(hash, groupName, num)))
+	return countFileRefs(files, hash) == 0
+}
+
+func countFileRefs(files *bolt.Bucket, hash string) (n int) {
+	prefix := fileRefPrefix(hash)
+	cur := files.Cursor()
+	for k, _ := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cur.Next() {
+		n++
+	}
+	return n
+}
+
+// FileRefs lists the group articles using an article file
+func (ar *Articles) FileRefs(hash string) (res []FileRef, err error) {
+	err = ar.db.View(func(tx *bolt.Tx) error {
+		files := tx.Bucket([]byte("files"))
+		if files == nil {
+			return nil
+		}
+
+		prefix := fileRefPrefix(hash)
+		cur := files.Cursor()
+		for k, _ := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cur.Next() {
+			if ref, ok := decodeFileRefKey(hash, k); ok {
+				res = append(res, ref)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// releaseFiles removes the article files that are no longer referenced. It
+// must be called once the transaction removing the references is committed.
+func (ar *Articles) releaseFiles(hashes []string) error {
+	ar.filesLock.Lock()
+	defer ar.filesLock.Unlock()
+	return ar.releaseFilesLocked(hashes)
+}
+
+// releaseFilesLocked is releaseFiles for callers holding filesLock
+func (ar *Articles) releaseFilesLocked(hashes []string) error {
+	for _, hash := range hashes {
+		var refs int
+		err := ar.db.View(func(tx *bolt.Tx) error {
+			if files := tx.Bucket([]byte("files")); files != nil {
+				refs = countFileRefs(files, hash)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		} else if refs > 0 {
+			continue
+		}
+
+		dir, fname := ar.getPath(hash)
+		err = os.Remove(path.Join(dir, fname))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		log.Printf("INFO: Removed article file %s, no longer referenced by any group", hash)
+	}
+	return nil
+}
+
+// rebuildFileRefs recreates the files bucket from the article numbers of
+// every group
+func rebuildFileRefs(tx *bolt.Tx) error {
+	if tx.Bucket([]byte("files")) != nil {
+		panicIfError(tx.DeleteBucket([]byte("files")))
+	}
+	_, err := tx.CreateBucket([]byte("files"))
+	panicIfError(err)
+
+	groups := tx.Bucket([]byte("groups"))
+	if groups == nil {
+		return nil
+	}
+	return groups.ForEach(func(name, v []byte) error {
+		if v != nil {
+			return nil
+		}
+
+		prefix := []byte(NumFilePrefix)
+		cur := groups.Bucket(name).Cursor()
+		for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+			num, err := decodeIntKey(NumFilePrefix, k)
+			if err != nil {
+				return err
+			}
+			addFileRef(tx, string(v), string(name), num)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,286 @@
+package articles
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"github.com/coreos/bbolt"
+)
+
+// TmpDir is the directory, relative to the data directory, where articles are
+// written before being moved in place
+const TmpDir = "tmp"
+
+func (ar *Articles) readArticleFile(hash string) ([]byte, error) {
+	dir, fname := ar.getPath(hash)
+	return ioutil.ReadFile(path.Join(dir, fname))
+}
+
+// lineCounter counts the bytes and lines written to it
+type lineCounter struct {
+	bytes int
+	lines int
+}
+
+func (c *lineCounter) Write(p []byte) (int, error) {
+	c.bytes += len(p)
+	c.lines += bytes.Count(p, []byte("\n"))
+	return len(p), nil
+}
+
+// writeArticle streams an article to a synced temporary file, hashing it on
+// the way. The file must then be moved in place with installArticle, or
+// removed.
+func (ar *Articles) writeArticle(header []byte, body io.Reader) (tmpName, hash string, size, lines int, err error) {
+	tmpDir := path.Join(ar.StorageDir, "data", TmpDir)
+	err = os.MkdirAll(tmpDir, 0755)
+	if err != nil {
+		return
+	}
+
+	f, err := ioutil.TempFile(tmpDir, "article-")
+	if err != nil {
+		return
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	var hasher = sha256.New()
+	var counter = new(lineCounter)
+	var w = io.MultiWriter(f, hasher)
+
+	_, err = w.Write(header)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(io.MultiWriter(w, counter), body)
+	if err != nil {
+		return
+	}
+	err = f.Sync()
+	if err != nil {
+		return
+	}
+	err = f.Chmod(0644)
+	if err != nil {
+		return
+	}
+
+	tmpName = f.Name()
+	hash = hex.EncodeToString(hasher.Sum(nil))
+	size = len(header) + counter.bytes
+	lines = counter.lines
+	return
+}
+
+// installArticle moves a temporary article file in place under its hash.
+// created is false if a file with the same content was already stored, the
+// temporary file is then removed. filesLock must be held until the file is
+// referenced in the index, or removed.
+func (ar *Articles) installArticle(tmpName, hash string) (created bool, err error) {
+	dir, fname := ar.getPath(hash)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		os.Remove(tmpName)
+		return
+	}
+
+	_, statErr := os.Stat(path.Join(dir, fname))
+	created = os.IsNotExist(statErr)
+	if !created {
+		err = os.Remove(tmpName)
+		return
+	}
+
+	err = os.Rename(tmpName, path.Join(dir, fname))
+	if err != nil {
+		os.Remove(tmpName)
+		return
+	}
+	err = syncDir(dir)
+	return
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// referencedFiles returns the hashes of the article files in the index
+func (ar *Articles) referencedFiles() (map[string]bool, error) {
+	var res = map[string]bool{}
+	err := ar.db.View(func(tx *bolt.Tx) error {
+		if msgids := tx.Bucket([]byte("msgids")); msgids != nil {
+			prefix := []byte(MsgIdFilePrefix)
+			cur := msgids.Cursor()
+			for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+				res[string(v)] = true
+			}
+		}
+
+		groups := tx.Bucket([]byte("groups"))
+		if groups == nil {
+			return nil
+		}
+		return groups.ForEach(func(name, v []byte) error {
+			if v != nil {
+				return nil
+			}
+			prefix := []byte(NumFilePrefix)
+			cur := groups.Bucket(name).Cursor()
+			for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+				res[string(v)] = true
+			}
+			return nil
+		})
+	})
+	return res, err
+}
+
+// hashFile returns the SHA-256 hash of a file
+func hashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var hasher = sha256.New()
+	_, err = io.Copy(hasher, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// eachArticleFile calls fn with the hash, the path and the file information of
+// every stored article file
+func (ar *Articles) eachArticleFile(fn func(hash, fpath string, info os.FileInfo) error) error {
+	dataDir := path.Join(ar.StorageDir, "data")
+	dirs1, err := ioutil.ReadDir(dataDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, d1 := range dirs1 {
+		if !d1.IsDir() || len(d1.Name()) != 2 {
+			continue
+		}
+		dirs2, err := ioutil.ReadDir(path.Join(dataDir, d1.Name()))
+		if err != nil {
+			return err
+		}
+		for _, d2 := range dirs2 {
+			if !d2.IsDir() || len(d2.Name()) != 2 {
+				continue
+			}
+			dir := path.Join(dataDir, d1.Name(), d2.Name())
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				return err
+			}
+			for _, file := range files {
+				if file.IsDir() {
+					continue
+				}
+				err = fn(file.Name(), path.Join(dir, file.Name()), file)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// KeyRunningSince, in the meta bucket, is the time the server last started.
+// It is removed on a clean shutdown.
+var KeyRunningSince = []byte("running-since")
+
+// Recover cleans up after a crash, it must be called before posting articles.
+// Temporary files of interrupted posts are removed. If the server did not shut
+// down cleanly, the article files written since it started are checked: the
+// files that never got indexed are removed and the files whose content does
+// not match their hash are reported. Older files are left to Fsck.
+func (ar *Articles) Recover() error {
+	tmpDir := path.Join(ar.StorageDir, "data", TmpDir)
+	tmpFiles, err := ioutil.ReadDir(tmpDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, file := range tmpFiles {
+		log.Printf("INFO: Removing interrupted article %s", file.Name())
+		err = os.Remove(path.Join(tmpDir, file.Name()))
+		if err != nil {
+			return err
+		}
+	}
+
+	var since time.Time
+	var crashed bool
+	err = ar.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte("meta"))
+		panicIfError(err)
+
+		if started, err := btoi(meta.Get(KeyRunningSince)); err == nil {
+			since = time.Unix(0, started)
+			crashed = true
+		}
+		return meta.Put(KeyRunningSince, itob(time.Now().UnixNano()))
+	})
+	if err != nil {
+		return err
+	}
+	ar.running = true
+
+	if !crashed {
+		return nil
+	}
+	log.Printf("INFO: Checking article files written since %v", since)
+
+	referenced, err := ar.referencedFiles()
+	if err != nil {
+		return err
+	}
+
+	var orphans, mismatches int
+	err = ar.eachArticleFile(func(hash, fpath string, info os.FileInfo) error {
+		if info.ModTime().Before(since) {
+			return nil
+		} else if !referenced[hash] {
+			log.Printf("INFO: Removing orphan article file %s", hash)
+			orphans++
+			return os.Remove(fpath)
+		}
+
+		actual, err := hashFile(fpath)
+		if err != nil {
+			return err
+		} else if actual != hash {
+			log.Printf("ERROR: Article file %s is corrupt, its content hash is %s", hash, actual)
+			mismatches++
+		}
+		return nil
+	})
+	if orphans > 0 || mismatches > 0 {
+		log.Printf("INFO: Recovery removed %d orphan files, found %d corrupt files", orphans, mismatches)
+	}
+	return err
+}
@@ -0,0 +1,376 @@
+package articles
+
+import (
+	"bytes"
+	"errors"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/bbolt"
+)
+
+const (
+	MsgIdRefsPrefix       = "msgid-refs."       // message-id to references
+	MsgIdRootPrefix       = "msgid-root."       // message-id to thread root message-id
+	ThreadMemberPrefix    = "thread-member."    // thread root and message-id to article number
+	ThreadCountPrefix     = "thread-count."     // thread root to number of articles
+	ThreadActivityPrefix  = "thread-activity."  // thread root to last activity
+	ActivityThreadPrefix  = "activity-thread."  // last activity and thread root to thread root
+	MsgIdSupersededPrefix = "msgid-superseded." // message-id to the message-id of the article superseding it
+	threadMemberSep       = " "
+)
+
+var ErrNoThread = errors.New("No such thread")
+
+// ThreadNode is an article in a thread. Articles that are referenced but were
+// never received have a zero Num.
+type ThreadNode struct {
+	MsgId    string
+	Num      int64
+	Children []*ThreadNode
+}
+
+type Thread struct {
+	Root         *ThreadNode
+	Count        int64
+	LastActivity time.Time
+}
+
+type ThreadSummary struct {
+	Root         string
+	RootNum      int64
+	Count        int64
+	LastActivity time.Time
+}
+
+var msgIdRegexp = regexp.MustCompile("<[^<>]+>")
+
+// readReferences returns the parent chain of an article from its References
+// header, or its In-Reply-To header if there are no references.
+func readReferences(header textproto.MIMEHeader) []string {
+	refs := msgIdRegexp.FindAllString(header.Get("References"), -1)
+	if len(refs) == 0 {
+		refs = msgIdRegexp.FindAllString(header.Get("In-Reply-To"), 1)
+	}
+	return refs
+}
+
+// indexThread links an article to its thread in the group bucket
+func indexThread(grp *bolt.Bucket, msgId string, num int64, refs []string, now time.Time) {
+	root := msgId
+	if len(refs) > 0 {
+		root = refs[0]
+		if r := grp.Get(encodeStrKey(MsgIdRootPrefix, root)); r != nil {
+			root = string(r)
+		}
+	}
+
+	panicIfError(grp.Put(encodeStrKey(MsgIdRefsPrefix, msgId), []byte(strings.Join(refs, " "))))
+	panicIfError(grp.Put(encodeStrKey(MsgIdRootPrefix, msgId), []byte(root)))
+	panicIfError(grp.Put(threadMemberKey(root, msgId), itob(num)))
+	addThreadActivity(grp, root, 1, now)
+
+	// A parent arrived late, articles that were threaded under it now belong
+	// to its thread.
+	if root != msgId {
+		mergeThread(grp, msgId, root)
+	}
+}
+
+func threadMemberKey(root, msgId string) []byte {
+	return encodeStrKey(ThreadMemberPrefix, root+threadMemberSep+msgId)
+}
+
+func addThreadActivity(grp *bolt.Bucket, root string, count int64, now time.Time) {
+	last, err := btoi(grp.Get(encodeStrKey(ThreadActivityPrefix, root)))
+	if err == nil {
+		panicIfError(grp.Delete(activityThreadKey(last, root)))
+		if last > now.UnixNano() {
+			now = time.Unix(0, last)
+		}
+	}
+	total, err := btoi(grp.Get(encodeStrKey(ThreadCountPrefix, root)))
+	if err != nil {
+		total = 0
+	}
+
+	panicIfError(grp.Put(encodeStrKey(ThreadCountPrefix, root), itob(total+count)))
+	panicIfError(grp.Put(encodeStrKey(ThreadActivityPrefix, root), itob(now.UnixNano())))
+	panicIfError(grp.Put(activityThreadKey(now.UnixNano(), root), []byte(root)))
+}
+
+func activityThreadKey(activity int64, root string) []byte {
+	return append(encodeIntKey(ActivityThreadPrefix, activity), []byte(root)...)
+}
+
+// mergeThread moves every article of the thread rooted at from to the thread
+// rooted at to.
+func mergeThread(grp *bolt.Bucket, from, to string) {
+	prefix := encodeStrKey(ThreadMemberPrefix, from+threadMemberSep)
+	var members [][]byte
+	var nums [][]byte
+	cur := grp.Cursor()
+	for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+		members = append(members, append([]byte(nil), k[len(prefix):]...))
+		nums = append(nums, append([]byte(nil), v...))
+	}
+	if len(members) == 0 {
+		return
+	}
+
+	for i, member := range members {
+		panicIfError(grp.Delete(append(append([]byte(nil), prefix...), member...)))
+		panicIfError(grp.Put(threadMemberKey(to, string(member)), nums[i]))
+		panicIfError(grp.Put(encodeStrKey(MsgIdRootPrefix, string(member)), []byte(to)))
+	}
+
+	activity, err := btoi(grp.Get(encodeStrKey(ThreadActivityPrefix, from)))
+	if err == nil {
+		panicIfError(grp.Delete(activityThreadKey(activity, from)))
+	}
+	panicIfError(grp.Delete(encodeStrKey(ThreadActivityPrefix, from)))
+	panicIfError(grp.Delete(encodeStrKey(ThreadCountPrefix, from)))
+	addThreadActivity(grp, to, int64(len(members)), time.Unix(0, activity))
+}
+
+// GetThread returns the thread tree containing the article
+func (ar *Articles) GetThread(groupName, msgId string) (thread *Thread, err error) {
+	err = ar.db.View(func(tx *bolt.Tx) error {
+		grp, err := groupBucket(tx, groupName)
+		if err != nil {
+			return err
+		}
+
+		root := grp.Get(encodeStrKey(MsgIdRootPrefix, msgId))
+		if root == nil {
+			return ErrNoThread
+		}
+
+		thread = readThread(grp, string(root))
+		return nil
+	})
+	return
+}
+
+func readThread(grp *bolt.Bucket, root string) *Thread {
+	var thread = new(Thread)
+	thread.Count, _ = btoi(grp.Get(encodeStrKey(ThreadCountPrefix, root)))
+	if activity, err := btoi(grp.Get(encodeStrKey(ThreadActivityPrefix, root))); err == nil {
+		thread.LastActivity = time.Unix(0, activity)
+	}
+
+	nodes := map[string]*ThreadNode{}
+	parents := map[*ThreadNode]*ThreadNode{}
+	node := func(msgId string) *ThreadNode {
+		msgId = supersededBy(grp, msgId)
+		n := nodes[msgId]
+		if n == nil {
+			n = &ThreadNode{MsgId: msgId}
+			nodes[msgId] = n
+		}
+		return n
+	}
+	isAncestor := func(a, n *ThreadNode) bool {
+		for ; n != nil; n = parents[n] {
+			if n == a {
+				return true
+			}
+		}
+		return false
+	}
+
+	var members []*ThreadNode
+	prefix := encodeStrKey(ThreadMemberPrefix, root+threadMemberSep)
+	cur := grp.Cursor()
+	for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+		n := node(string(k[len(prefix):]))
+		n.Num, _ = btoi(v)
+		members = append(members, n)
+	}
+
+	// Link the reference chains, the article own parent takes precedence over
+	// the links deduced from other articles.
+	for _, n := range members {
+		refs := strings.Fields(string(grp.Get(encodeStrKey(MsgIdRefsPrefix, n.MsgId))))
+		var prev *ThreadNode
+		for _, ref := range refs {
+			r := node(ref)
+			if prev != nil && parents[r] == nil && !isAncestor(r, prev) {
+				parents[r] = prev
+			}
+			prev = r
+		}
+		if prev != nil && !isAncestor(n, prev) {
+			parents[n] = prev
+		}
+	}
+
+	thread.Root = node(root)
+	delete(parents, thread.Root)
+	for _, n := range nodes {
+		if n == thread.Root {
+			continue
+		}
+		if parents[n] == nil {
+			parents[n] = thread.Root
+		}
+	}
+
+	// Children sorted by article number, missing articles last
+	var sorted []*ThreadNode
+	for _, n := range nodes {
+		sorted = append(sorted, n)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if (a.Num == 0) != (b.Num == 0) {
+			return b.Num == 0
+		} else if a.Num != b.Num {
+			return a.Num < b.Num
+		}
+		return a.MsgId < b.MsgId
+	})
+	for _, n := range sorted {
+		if p := parents[n]; p != nil {
+			p.Children = append(p.Children, n)
+		}
+	}
+	return thread
+}
+
+// ListThreads returns the threads of a group, most recently active first
+func (ar *Articles) ListThreads(groupName string, offset, limit int) (res []*ThreadSummary, err error) {
+	err = ar.db.View(func(tx *bolt.Tx) error {
+		grp, err := groupBucket(tx, groupName)
+		if err != nil {
+			return err
+		}
+
+		prefix := []byte(ActivityThreadPrefix)
+		cur := grp.Cursor()
+		k, v := cur.Seek(append(append([]byte(nil), prefix...), 0xff))
+		if k == nil {
+			k, v = cur.Last()
+		} else {
+			k, v = cur.Prev()
+		}
+		for skipped := 0; k != nil && bytes.HasPrefix(k, prefix) && (limit < 0 || len(res) < limit); k, v = cur.Prev() {
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			root := string(v)
+			t := &ThreadSummary{Root: supersededBy(grp, root)}
+			t.Count, _ = btoi(grp.Get(encodeStrKey(ThreadCountPrefix, root)))
+			if activity, err := btoi(grp.Get(encodeStrKey(ThreadActivityPrefix, root))); err == nil {
+				t.LastActivity = time.Unix(0, activity)
+			}
+			t.RootNum, _ = btoi(grp.Get(threadMemberKey(root, t.Root)))
+			res = append(res, t)
+		}
+		return nil
+	})
+	return
+}
+
+// unindexThread removes an article from its thread. The article remains in
+// the thread tree as a missing article if others refer to it, and the thread
+// is removed with its last article.
+func unindexThread(grp *bolt.Bucket, msgId string) {
+	root := string(grp.Get(encodeStrKey(MsgIdRootPrefix, msgId)))
+	if root == "" {
+		return
+	}
+	panicIfError(grp.Delete(threadMemberKey(root, msgId)))
+
+	count, err := btoi(grp.Get(encodeStrKey(ThreadCountPrefix, root)))
+	if err == nil && count > 1 {
+		panicIfError(grp.Put(encodeStrKey(ThreadCountPrefix, root), itob(count-1)))
+		return
+	}
+
+	// Last article of the thread
+	prefix := encodeStrKey(ThreadMemberPrefix, root+threadMemberSep)
+	var members []string
+	cur := grp.Cursor()
+	for k, _ := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cur.Next() {
+		members = append(members, string(k[len(prefix):]))
+	}
+	for _, member := range members {
+		panicIfError(grp.Delete(threadMemberKey(root, member)))
+	}
+
+	if activity, err := btoi(grp.Get(encodeStrKey(ThreadActivityPrefix, root))); err == nil {
+		panicIfError(grp.Delete(activityThreadKey(activity, root)))
+	}
+	panicIfError(grp.Delete(encodeStrKey(ThreadActivityPrefix, root)))
+	panicIfError(grp.Delete(encodeStrKey(ThreadCountPrefix, root)))
+	panicIfError(grp.Delete(encodeStrKey(MsgIdRootPrefix, msgId)))
+	panicIfError(grp.Delete(encodeStrKey(MsgIdRefsPrefix, msgId)))
+}
+
+// replaceInThread moves an article in the place of another in its thread, the
+// replaced article is to be removed afterwards.
+func replaceInThread(grp *bolt.Bucket, oldMsgId, newMsgId string, newNum int64, now time.Time) {
+	root := string(grp.Get(encodeStrKey(MsgIdRootPrefix, oldMsgId)))
+	if root == "" {
+		return
+	}
+
+	unindexThread(grp, newMsgId)
+	// Articles superseded by oldMsgId now lead to newMsgId directly, the
+	// entries leading to oldMsgId are removed with it
+	for _, msgId := range supersededMsgIds(grp, oldMsgId) {
+		panicIfError(grp.Put(encodeStrKey(MsgIdSupersededPrefix, msgId), []byte(newMsgId)))
+	}
+	panicIfError(grp.Put(encodeStrKey(MsgIdRefsPrefix, newMsgId), grp.Get(encodeStrKey(MsgIdRefsPrefix, oldMsgId))))
+	panicIfError(grp.Put(encodeStrKey(MsgIdRootPrefix, newMsgId), []byte(root)))
+	panicIfError(grp.Put(threadMemberKey(root, newMsgId), itob(newNum)))
+	panicIfError(grp.Put(encodeStrKey(MsgIdSupersededPrefix, oldMsgId), []byte(newMsgId)))
+	addThreadActivity(grp, root, 1, now)
+}
+
+// supersededMsgIds returns the Message-IDs of the articles superseded by msgId
+func supersededMsgIds(grp *bolt.Bucket, msgId string) (res []string) {
+	prefix := []byte(MsgIdSupersededPrefix)
+	cur := grp.Cursor()
+	for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+		if string(v) == msgId {
+			res = append(res, string(k[len(prefix):]))
+		}
+	}
+	return res
+}
+
+// unindexSuperseded removes the entries leading to a removed article from the
+// articles it superseded, and from the articles these superseded
+func unindexSuperseded(grp *bolt.Bucket, msgId string) {
+	var removed = []string{msgId}
+	for len(removed) > 0 {
+		var next []string
+		for _, msgId := range removed {
+			for _, old := range supersededMsgIds(grp, msgId) {
+				panicIfError(grp.Delete(encodeStrKey(MsgIdSupersededPrefix, old)))
+				next = append(next, old)
+			}
+		}
+		removed = next
+	}
+}
+
+// supersededBy returns the Message-ID of the article that replaced msgId in
+// threads, or msgId itself
+func supersededBy(grp *bolt.Bucket, msgId string) string {
+	// Bounded to stop on supersede loops
+	for i := 0; i < 16; i++ {
+		next := grp.Get(encodeStrKey(MsgIdSupersededPrefix, msgId))
+		if next == nil {
+			break
+		}
+		msgId = string(next)
+	}
+	return msgId
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"log"
+	"time"
 )
 
 func panicIfError(err error) {
@@ -48,3 +49,11 @@ func decodeStrKey(prefix string, data []byte) (string, error) {
 	}
 	return string(data[len([]byte(prefix)):]), nil
 }
+
+func encodeTime(t time.Time) []byte {
+	return []byte(t.Format(time.RFC3339))
+}
+
+func decodeTime(d []byte) (time.Time, error) {
+	return time.Parse(time.RFC3339, string(d))
+}
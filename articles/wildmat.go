@@ -0,0 +1,48 @@
+package articles
+
+import (
+	"strings"
+)
+
+// MatchWildmat matches a name against a wildmat as defined by RFC 3977
+// section 4: comma separated patterns using "*" and "?", where the rightmost
+// matching pattern wins and patterns starting with "!" exclude names.
+func MatchWildmat(wildmat, name string) bool {
+	patterns := strings.Split(wildmat, ",")
+	for i := len(patterns) - 1; i >= 0; i-- {
+		pattern := strings.TrimSpace(patterns[i])
+		negate := strings.HasPrefix(pattern, "!")
+		if negate {
+			pattern = pattern[1:]
+		}
+		if matchPattern([]rune(pattern), []rune(name)) {
+			return !negate
+		}
+	}
+	return false
+}
+
+func matchPattern(pattern, name []rune) bool {
+	var p, n int
+	var star, starName = -1, 0
+	for n < len(name) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == name[n]):
+			p++
+			n++
+		case p < len(pattern) && pattern[p] == '*':
+			star, starName = p, n
+			p++
+		case star >= 0:
+			// let the last star match one more character
+			starName++
+			p, n = star+1, starName
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
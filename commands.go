@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mildred/newsweb/accounts"
+	"github.com/mildred/newsweb/articles"
+	"github.com/mildred/newsweb/validations"
+)
+
+// runCommand runs an administration command given on the command line instead
+// of starting the server.
+func runCommand(art *articles.Articles, val *validations.Validations, acc *accounts.Accounts, args []string) error {
+	switch args[0] {
+	case "group":
+		return runGroupCommand(art, args[1:])
+	case "reindex":
+		n, err := art.Reindex()
+		if err != nil {
+			return err
+		}
+		log.Printf("INFO: Reindexed %d articles", n)
+		return nil
+	case "fsck":
+		repair := len(args) == 2 && args[1] == "-repair"
+		if len(args) > 2 || (len(args) == 2 && !repair) {
+			return fmt.Errorf("usage: fsck [-repair]")
+		}
+		problems, err := art.Fsck(repair)
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("INFO: Found %d problems", len(problems))
+		return nil
+	case "revoke-trust":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: revoke-trust EMAIL")
+		}
+		err := val.RevokeTrust(args[1])
+		if err != nil {
+			return err
+		}
+		log.Printf("INFO: Revoked trust for %s", args[1])
+		return nil
+	case "reset-password":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: reset-password EMAIL")
+		}
+		err := acc.ResetPassword(args[1])
+		if err != nil {
+			return err
+		}
+		log.Printf("INFO: Reset password for %s", args[1])
+		return nil
+	default:
+		return fmt.Errorf("unknown command %s", args[0])
+	}
+}
+
+const groupUsage = `usage: group create GROUP [DESCRIPTION]
+       group describe GROUP DESCRIPTION
+       group rename GROUP NEW-NAME
+       group delete GROUP
+       group list
+       group stat GROUP
+       group policy GROUP POLICY
+       group moderators GROUP [EMAIL...]
+       group expire GROUP [age=DURATION] [count=N] [size=BYTES]`
+
+func runGroupCommand(art *articles.Articles, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(groupUsage)
+	}
+
+	switch {
+	case args[0] == "create" && (len(args) == 2 || len(args) == 3):
+		var descr string
+		if len(args) == 3 {
+			descr = args[2]
+		}
+		err := art.CreateGroup(args[1], descr)
+		if err != nil {
+			return err
+		}
+		log.Printf("INFO: Created group %s", args[1])
+		return nil
+
+	case args[0] == "describe" && len(args) == 3:
+		err := art.SetGroupDescription(args[1], args[2])
+		if err != nil {
+			return err
+		}
+		log.Printf("INFO: Set description of %s", args[1])
+		return nil
+
+	case args[0] == "rename" && len(args) == 3:
+		err := art.RenameGroup(args[1], args[2])
+		if err != nil {
+			return err
+		}
+		log.Printf("INFO: Renamed group %s to %s", args[1], args[2])
+		return nil
+
+	case args[0] == "delete" && len(args) == 2:
+		err := art.DeleteGroup(args[1])
+		if err != nil {
+			return err
+		}
+		log.Printf("INFO: Deleted group %s", args[1])
+		return nil
+
+	case args[0] == "list" && len(args) == 1:
+		grps, err := art.ListGroups()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+		for _, grp := range grps {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", grp.Name, grp.Count, grp.Policy, grp.Description)
+		}
+		return w.Flush()
+
+	case args[0] == "stat" && len(args) == 2:
+		grp, err := art.GetGroup(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("name:        %s\n", grp.Name)
+		fmt.Printf("description: %s\n", grp.Description)
+		fmt.Printf("count:       %d\n", grp.Count)
+		fmt.Printf("low:         %d\n", grp.Low)
+		fmt.Printf("high:        %d\n", grp.High)
+		fmt.Printf("policy:      %s\n", grp.Policy)
+		fmt.Printf("moderators:  %s\n", strings.Join(grp.Moderators, " "))
+		fmt.Printf("expiry:      %s\n", grp.Expiry)
+		return nil
+
+	case args[0] == "policy" && len(args) == 3:
+		policy, err := articles.ParsePolicy(args[2])
+		if err != nil {
+			return err
+		}
+		err = art.SetGroupPolicy(args[1], policy)
+		if err != nil {
+			return err
+		}
+		log.Printf("INFO: Set policy of %s to %s", args[1], policy)
+		return nil
+
+	case args[0] == "moderators" && len(args) >= 2:
+		err := art.SetGroupModerators(args[1], args[2:])
+		if err != nil {
+			return err
+		}
+		log.Printf("INFO: Set moderators of %s to %v", args[1], args[2:])
+		return nil
+
+	case args[0] == "expire" && len(args) >= 2:
+		expiry, err := articles.ParseExpiry(args[2:])
+		if err != nil {
+			return err
+		}
+		err = art.SetGroupExpiry(args[1], expiry)
+		if err != nil {
+			return err
+		}
+		log.Printf("INFO: Set expiry of %s to %v", args[1], expiry)
+		return nil
+
+	default:
+		return fmt.Errorf(groupUsage)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mildred/newsweb/articles"
+)
+
+// Expirer periodically removes articles according to the expiry rules of
+// their groups.
+type Expirer struct {
+	Articles *articles.Articles
+	Interval time.Duration
+}
+
+func (e *Expirer) Start(ctx context.Context, wg *sync.WaitGroup) {
+	runPeriodically(ctx, wg, "expirer", e.Interval, e.expire)
+}
+
+func (e *Expirer) expire() {
+	n, err := e.Articles.Expire(time.Now())
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+	} else if n > 0 {
+		log.Printf("INFO: Expired %d articles", n)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mildred/newsweb/accounts"
+	"github.com/mildred/newsweb/articles"
+	"github.com/mildred/newsweb/validations"
+)
+
+// Janitor periodically removes expired validation tokens and the pending
+// articles and passwords waiting for them, as well as expired moderation
+// requests and the Message-IDs of removed articles past their history.
+type Janitor struct {
+	Articles    *articles.Articles
+	Validations *validations.Validations
+	Accounts    *accounts.Accounts
+	Interval    time.Duration
+}
+
+func (j *Janitor) Start(ctx context.Context, wg *sync.WaitGroup) {
+	runPeriodically(ctx, wg, "janitor", j.Interval, j.clean)
+}
+
+func (j *Janitor) clean() {
+	now := time.Now()
+
+	tokens, err := j.Validations.CleanTokensBefore(now)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+
+	numTokenPending, err := j.Articles.RemovePendingTokens(tokens)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+
+	numPasswords, err := j.Accounts.RemovePendingTokens(tokens)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+
+	numExpiredPending, err := j.Articles.CleanExpiredPending(now)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+
+	numModeration, err := j.Articles.CleanExpiredModeration(now)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+
+	numMsgIds, err := j.Articles.CleanForgottenMsgIds(now)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+
+	if len(tokens) > 0 || numTokenPending > 0 || numExpiredPending > 0 || numPasswords > 0 || numModeration > 0 || numMsgIds > 0 {
+		log.Printf("INFO: Janitor removed %d expired tokens, %d pending articles, %d pending passwords, %d moderation requests and %d removed Message-IDs",
+			len(tokens), numTokenPending+numExpiredPending, numPasswords, numModeration, numMsgIds)
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
 	"time"
 
 	"github.com/paulrosania/go-mail"
@@ -12,17 +15,43 @@ import (
 
 const (
 	UuidEmailValidation = "8ce7db75-31c1-4308-974e-0971c19fa158"
+	UuidModeration      = "3f4c9a2e-6d1b-4e8a-b2a7-5c0d9e7f1b64"
 )
 
 func genHexToken(size int) string {
 	var data = make([]byte, size)
 	_, _ = rand.Read(data)
-	var enc bytes.Buffer
-	base64.NewEncoder(base64.RawURLEncoding, &enc).Write(data)
-	return string(enc.Bytes())
+	return base64.RawURLEncoding.EncodeToString(data)
 }
 
 func (m *Mailer) GenValidationMail(to, token string) []byte {
+	return m.genValidationMail(to, token, fmt.Sprintf(`You, or someone that pass for you, is trying to send a message using the e-mail
+address: %s
+
+If you are not the author of the message, you can ignore this e-mail and the
+original message will be ignored.
+
+If you are the author of the message, you need to reply to this message to
+confirm you are the sender. If not, the message is going to be discarded.
+
+Once confirmed, your next messages are published without confirmation for a
+while if they carry this header, which is removed before publication:
+
+X-Trust-Key: %s`, to, token))
+}
+
+func (m *Mailer) GenPasswordMail(to, token string) []byte {
+	return m.genValidationMail(to, token, fmt.Sprintf(`You, or someone that pass for you, is trying to set a password to log-in to the
+news server using the e-mail address: %s
+
+If you did not ask for this, you can ignore this e-mail and the password will
+not be set.
+
+If you want to set this password, you need to reply to this message to confirm
+you own this e-mail address.`, to))
+}
+
+func (m *Mailer) genValidationMail(to, token, reason string) []byte {
 	tok := genHexToken(16)
 	msg := mail.NewMessage()
 	msg.Header = &mail.Header{}
@@ -35,15 +64,10 @@ func (m *Mailer) GenValidationMail(to, token string) []byte {
 		&mail.Part{
 			Text: fmt.Sprintf(`Please confirm your e-mail address
 
-You, or someone that pass for you, is trying to send a message using the e-mail
-address: %s
-
-If you are not the author of the message, you can ignore this e-mail and the
-original message will be ignored.
-
-If you are the author of the message, you need to reply to this message to
-confirm you are the sender. If not, the message is going to be discarded. When
-replying, you need to sign the message using your PGP secret key.
+%s When replying, you need to sign the message using your PGP secret key. The
+first time you confirm this address, attach your public key to the reply so it
+can be bound to the address. Later confirmations must be signed with the same
+key.
 
 
 ------------------------------------------------------------
@@ -53,7 +77,75 @@ mail type:      %s:%s
 secret token:   %s:t:%s
 e-mail address: %s:e:%s
 ------------------------------------------------------------
-`, to, tok, UuidEmailValidation, tok, token, tok, to),
+`, reason, tok, UuidEmailValidation, tok, token, tok, to),
+		},
+	}
+
+	return []byte(msg.RFC822(false))
+}
+
+// GenModerationMail builds the mail sent to moderators with the article
+// attached and the tokens to approve or reject it.
+func (m *Mailer) GenModerationMail(to []string, groups []string, approveToken, rejectToken string, article []byte) []byte {
+	tok := genHexToken(16)
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	text, _ := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	fmt.Fprintf(text, `An article is waiting for moderation
+
+The attached article was posted to the moderated newsgroups: %s
+
+To approve the article, reply to this message keeping only the approve line
+below. To reject it, reply keeping only the reject line. The reply must be
+signed using the PGP key bound to your e-mail address.
+
+
+------------------------------------------------------------
+Please keep the following text in your reply:
+
+mail type:      %s:%s
+approve token:  %s:a:%s
+reject token:   %s:r:%s
+------------------------------------------------------------
+`, strings.Join(groups, ", "), tok, UuidModeration, tok, approveToken, tok, rejectToken)
+
+	attachment, _ := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {"message/rfc822"},
+		"Content-Disposition": {"attachment"},
+	})
+	attachment.Write(article)
+	w.Close()
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.Mail)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC822))
+	fmt.Fprintf(&msg, "Subject: Moderation request for %s\r\n", strings.Join(groups, ", "))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n", w.Boundary())
+	fmt.Fprintf(&msg, "\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes()
+}
+
+// GenRejectionMail builds the mail notifying a poster that the moderators
+// rejected their article.
+func (m *Mailer) GenRejectionMail(to, msgId string, groups []string) []byte {
+	msg := mail.NewMessage()
+	msg.Header = &mail.Header{}
+	msg.Header.Add("From", m.Mail)
+	msg.Header.Add("To", to)
+	msg.Header.Add("Date", time.Now().Format(time.RFC822))
+	msg.Header.Add("Subject", "Your article was rejected by the moderators")
+	msg.Header.Add("Content-Type", "text/plain")
+	msg.Parts = []*mail.Part{
+		&mail.Part{
+			Text: fmt.Sprintf(`Your article was rejected by the moderators
+
+The article %s you posted to the moderated newsgroups %s was rejected by the
+moderators and will not be published.
+`, msgId, strings.Join(groups, ", ")),
 		},
 	}
 
@@ -4,7 +4,6 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -15,7 +14,9 @@ import (
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap-idle"
 	"github.com/emersion/go-imap/client"
-	"github.com/emersion/go-message/mail"
+	"golang.org/x/crypto/openpgp"
+
+	"github.com/mildred/newsweb/message"
 )
 
 func (m *Mailer) connect(ctx context.Context) (c *client.Client, err error) {
@@ -179,17 +180,25 @@ func (m *Mailer) readMessage(msg *imap.Message) error {
 	if part == nil {
 		return fmt.Errorf("No part available")
 	}
-	r, err := mail.CreateReader(part)
+	raw, err := ioutil.ReadAll(part)
 	if err != nil {
 		return err
 	}
 
-	for part, err := r.NextPart(); err != io.EOF; part, err = r.NextPart() {
-		var data []byte
-		data, err = ioutil.ReadAll(part.Body)
-		if err != nil {
-			return err
+	signed, err := message.ReadSigned(raw)
+	if err != nil {
+		log.Printf("ERROR: IMAP cannot read message: %v", err)
+		return nil
+	}
+
+	// Only look at the signed content, tokens outside of the signature are
+	// ignored.
+	for _, data := range signed.Text {
+		if mat := moderationUuidRegexp.FindStringSubmatch(string(data)); mat != nil {
+			m.readModeration(signed, mat[1], string(data))
+			continue
 		}
+
 		mat := validationUuidRegexp.FindStringSubmatch(string(data))
 		if mat == nil {
 			continue
@@ -206,15 +215,79 @@ func (m *Mailer) readMessage(msg *imap.Message) error {
 		}
 		email := mat[1]
 		log.Printf("INFO: IMAP received validation for %s with token %s", email, token)
-		err := m.Validations.ReceivedEmailToken(email, token)
-		if err != nil {
-			return err
-		}
+		m.receivedValidation(signed, email, token)
 	}
 	return nil
 }
 
+func (m *Mailer) receivedValidation(signed *message.Signed, email, token string) {
+	if m.Validations == nil {
+		log.Print("ERROR: IMAP validation received but no validation handler configured")
+		return
+	}
+
+	key, err := m.Validations.EmailKey(email)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return
+	}
+
+	var keyring = signed.Keys
+	if key != nil {
+		keyring = openpgp.EntityList{key}
+	}
+
+	signer, err := signed.Verify(keyring)
+	if err != nil {
+		log.Printf("ERROR: IMAP validation for %s has no valid signature: %v", email, err)
+		return
+	}
+
+	err = m.Validations.ReceivedEmailToken(email, token, signer)
+	if err != nil {
+		log.Printf("ERROR: IMAP validation for %s rejected: %v", email, err)
+	}
+}
+
+func (m *Mailer) readModeration(signed *message.Signed, tok, data string) {
+	approve := moderationApproveRegexp(tok).FindStringSubmatch(data)
+	reject := moderationRejectRegexp(tok).FindStringSubmatch(data)
+	if (approve == nil) == (reject == nil) {
+		log.Print("ERROR: IMAP moderation reply must contain exactly one of the approve or reject tokens")
+		return
+	}
+
+	var token = approve
+	if token == nil {
+		token = reject
+	}
+	log.Printf("INFO: IMAP received moderation with token %s (approve: %v)", token[1], approve != nil)
+
+	if m.Moderations == nil {
+		log.Print("ERROR: IMAP moderation received but no moderation handler configured")
+		return
+	}
+
+	keyring, err := m.Moderations.ModeratorKeys(token[1])
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return
+	}
+
+	signer, err := signed.Verify(keyring)
+	if err != nil {
+		log.Printf("ERROR: IMAP moderation has no valid moderator signature: %v", err)
+		return
+	}
+
+	err = m.Moderations.ReceivedModeration(token[1], approve != nil, signer)
+	if err != nil {
+		log.Printf("ERROR: IMAP moderation rejected: %v", err)
+	}
+}
+
 var validationUuidRegexp = regexp.MustCompile("(\\S*):" + regexp.QuoteMeta(UuidEmailValidation))
+var moderationUuidRegexp = regexp.MustCompile("(\\S*):" + regexp.QuoteMeta(UuidModeration))
 
 func validationTokenRegexp(uniqueTok string) *regexp.Regexp {
 	return regexp.MustCompile(regexp.QuoteMeta(uniqueTok) + ":t:(\\S*)")
@@ -222,6 +295,12 @@ func validationTokenRegexp(uniqueTok string) *regexp.Regexp {
 func validationEmailRegexp(uniqueTok string) *regexp.Regexp {
 	return regexp.MustCompile(regexp.QuoteMeta(uniqueTok) + ":e:(\\S*)")
 }
+func moderationApproveRegexp(uniqueTok string) *regexp.Regexp {
+	return regexp.MustCompile(regexp.QuoteMeta(uniqueTok) + ":a:(\\S*)")
+}
+func moderationRejectRegexp(uniqueTok string) *regexp.Regexp {
+	return regexp.MustCompile(regexp.QuoteMeta(uniqueTok) + ":r:(\\S*)")
+}
 
 func (m *Mailer) run(ctx context.Context, c *client.Client) error {
 	defer func() {
@@ -7,6 +7,8 @@ import (
 	"os"
 	"strings"
 	"sync"
+
+	"golang.org/x/crypto/openpgp"
 )
 
 type Mailer struct {
@@ -20,10 +22,17 @@ type Mailer struct {
 	PassFile    string
 	ImapDebug   bool
 	Validations Validations
+	Moderations Moderations
 }
 
 type Validations interface {
-	ReceivedEmailToken(email, token string) error
+	EmailKey(email string) (*openpgp.Entity, error)
+	ReceivedEmailToken(email, token string, key *openpgp.Entity) error
+}
+
+type Moderations interface {
+	ModeratorKeys(token string) (openpgp.EntityList, error)
+	ReceivedModeration(token string, approve bool, key *openpgp.Entity) error
 }
 
 func (m *Mailer) Start(ctx context.Context, wg *sync.WaitGroup) error {
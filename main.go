@@ -1,15 +1,21 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"sync"
+	"time"
 
+	"github.com/mildred/newsweb/accounts"
 	"github.com/mildred/newsweb/articles"
 	"github.com/mildred/newsweb/mailer"
 	"github.com/mildred/newsweb/server"
 	"github.com/mildred/newsweb/validations"
+	"github.com/mildred/newsweb/web"
 )
 
 type Config struct {
@@ -17,18 +23,49 @@ type Config struct {
 }
 
 func main() {
-	var ctx = mainContext()
+	err := run()
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+}
+
+// run configures and starts the server, or runs a command. Errors are
+// returned rather than fatal so the deferred Close calls release the stores.
+func run() error {
 	var art articles.Articles
 	var val validations.Validations
+	var acc accounts.Accounts
 	var srv server.Server
 	var mail mailer.Mailer
+	var jan Janitor
+	var exp Expirer
+	var www web.Web
 
 	defaultPassFd, _ := strconv.Atoi(os.Getenv("NEWSWEB_SMTP_PASS_FD"))
+	defaultDomain, _ := os.Hostname()
 	srv.Articles = &art
 	srv.Validations = &val
+	srv.Accounts = &acc
 	srv.Mailer = &mail
+	mail.Validations = &srv
+	mail.Moderations = &srv
+	jan.Articles = &art
+	jan.Validations = &val
+	jan.Accounts = &acc
+	exp.Articles = &art
+	www.Articles = &art
+	www.Poster = &server.Connection{Server: &srv}
+	www.Passwords = &srv
 	flag.StringVar(&art.StorageDir, "data", os.Getenv("NEWSWEB_DATA"), "Data path (NEWSWEB_DATA)")
 	flag.StringVar(&srv.ListenAddr, "listen-nntp", ":119", "Listen address for NNTP server")
+	flag.StringVar(&www.ListenAddr, "listen-http", ":8080", "Listen address for HTTP server, empty to disable")
+	flag.StringVar(&srv.Domain, "domain", defaultDomain, "Domain used to generate Message-IDs")
+	flag.DurationVar(&srv.PendingExpire, "pending-expire", 7*24*time.Hour, "How long posted articles wait for e-mail validation")
+	flag.DurationVar(&srv.ModerationExpire, "moderation-expire", 14*24*time.Hour, "How long articles to moderated groups wait for moderators")
+	flag.BoolVar(&srv.RequireExistingGroups, "require-existing-groups", false, "Reject posts to groups that do not exist instead of creating them")
+	flag.StringVar(&srv.ControlKeyring, "control-keyring", "", "Armored keyring of administrators allowed to send newgroup and rmgroup control articles")
+	flag.StringVar(&srv.AuditGroup, "audit-group", "local.audit", "Group where accepted group control articles are filed, empty to disable")
+	flag.IntVar(&srv.MaxCrossPost, "max-crosspost", 10, "Maximum number of groups an article can be posted to, 0 for no limit")
 	flag.StringVar(&mail.Mail, "email", "", "From e-mail")
 	flag.StringVar(&mail.Host, "mail-server", "localhost", "SMTP/IMAP Hostname")
 	flag.StringVar(&mail.SmtpPort, "smtp-port", "587", "SMTP submission port")
@@ -37,24 +74,72 @@ func main() {
 	flag.StringVar(&mail.Pass, "mail-pass", os.Getenv("NEWSWEB_MAIL_PASS"), "SMTP/IMAP Password (NEWSWEB_MAIL_PASS)")
 	flag.IntVar(&mail.PassFd, "mail-pass-fd", defaultPassFd, "SMTP Password from file-descriptor (NEWSWEB_MAIL_PASS_FD)")
 	flag.StringVar(&mail.PassFile, "mail-pass-file", os.Getenv("NEWSWEB_SMTP_PASS_FILE"), "SMTP Password from file (NEWSWEB_MAIL_PASS_FILE)")
+	flag.DurationVar(&val.TokenTTL, "token-ttl", validations.DefaultTokenTTL, "How long e-mail validation tokens are valid")
+	flag.DurationVar(&val.TrustWindow, "trust-window", validations.DefaultTrustWindow, "How long a validated e-mail address can post without validation")
+	flag.DurationVar(&acc.RequestInterval, "password-request-interval", accounts.DefaultRequestInterval, "Minimum time between two password requests for the same e-mail address")
+	flag.DurationVar(&jan.Interval, "janitor-interval", 10*time.Minute, "Interval between removals of expired tokens and pending articles")
+	flag.DurationVar(&art.MsgIdHistory, "msgid-history", articles.DefaultMsgIdHistory, "How long the Message-ID of a removed article is refused")
+	flag.DurationVar(&exp.Interval, "expire-interval", time.Hour, "Interval between removals of expired articles")
 	flag.BoolVar(&mail.ImapDebug, "imap-debug", false, "IMAP debug")
 	flag.Parse()
+	if jan.Interval <= 0 {
+		return fmt.Errorf("-janitor-interval must be positive, got %v", jan.Interval)
+	}
+	if exp.Interval <= 0 {
+		return fmt.Errorf("-expire-interval must be positive, got %v", exp.Interval)
+	}
+	www.Domain = srv.Domain
+	art.ServerName = srv.Domain
 	val.StorageDir = art.StorageDir
+	acc.StorageDir = art.StorageDir
 
 	err := art.Open()
 	if err != nil {
-		log.Fatalf("ERROR: %v", err)
+		return err
 	}
 	defer art.Close()
 
 	err = val.Open()
 	if err != nil {
-		log.Fatalf("ERROR: %v", err)
+		return err
 	}
 	defer val.Close()
 
-	err = srv.Start(ctx)
+	err = acc.Open()
+	if err != nil {
+		return err
+	}
+	defer acc.Close()
+
+	if flag.NArg() > 0 {
+		return runCommand(&art, &val, &acc, flag.Args())
+	}
+
+	err = art.Recover()
+	if err != nil {
+		return err
+	}
+
+	// Stop the background tasks before the stores are closed, also when a
+	// server fails to start
+	ctx, cancel := context.WithCancel(mainContext())
+	var wg = new(sync.WaitGroup)
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	jan.Start(ctx, wg)
+	exp.Start(ctx, wg)
+
+	err = www.Start(ctx, wg)
+	if err != nil {
+		return err
+	}
+
+	err = srv.Start(ctx, wg)
 	if err != nil && ctx.Err() == nil {
-		log.Fatalf("ERROR: %v", err)
+		return err
 	}
+	return nil
 }
@@ -0,0 +1,57 @@
+package message
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"hash"
+	"strings"
+)
+
+const (
+	HeaderControl    = "Control"
+	HeaderSupersedes = "Supersedes"
+	HeaderCancelLock = "Cancel-Lock"
+	HeaderCancelKey  = "Cancel-Key"
+)
+
+// cancelLockHashes are the hash algorithms of RFC 8315 accepted for
+// Cancel-Lock, SHA-1 is deprecated and not supported
+var cancelLockHashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// MatchCancelLock tells if one of the Cancel-Key elements of a cancel or
+// superseding article matches one of the Cancel-Lock elements of the article
+// it replaces, as specified by RFC 8315.
+func MatchCancelLock(locks, keys []string) bool {
+	for _, key := range strings.Fields(strings.Join(keys, " ")) {
+		scheme, keyString := splitCancelElement(key)
+		newHash := cancelLockHashes[scheme]
+		if newHash == nil {
+			continue
+		}
+
+		h := newHash()
+		h.Write([]byte(keyString))
+		expected := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+		for _, lock := range strings.Fields(strings.Join(locks, " ")) {
+			lockScheme, lockString := splitCancelElement(lock)
+			if lockScheme == scheme && subtle.ConstantTimeCompare([]byte(lockString), []byte(expected)) == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func splitCancelElement(element string) (scheme, value string) {
+	kv := strings.SplitN(element, ":", 2)
+	if len(kv) != 2 {
+		return "", ""
+	}
+	return strings.ToLower(kv[0]), kv[1]
+}
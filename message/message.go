@@ -1,17 +1,22 @@
 package message
 
 import (
+	"bytes"
 	"io"
 	"io/ioutil"
 	"strings"
 
 	"github.com/paulrosania/go-mail"
+	"golang.org/x/crypto/openpgp"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
 )
 
 const (
 	HeaderFrom       = mail.FromFieldName
 	HeaderMessageId  = mail.MessageIDFieldName
 	HeaderNewsgroups = "Newsgroups"
+	HeaderXref       = "Xref"
+	HeaderApproved   = "Approved"
 )
 
 type Message struct {
@@ -57,11 +62,103 @@ func (m *Message) HeaderValue(header string) string {
 	return strings.Join(m.HeaderValues(header), " ")
 }
 
+// AddHeader inserts a header field at the top of a raw message, using the same
+// line endings as the message.
+func AddHeader(data []byte, name, value string) []byte {
+	eol := "\n"
+	if bytes.Contains(data, []byte("\r\n")) {
+		eol = "\r\n"
+	}
+	return append([]byte(name+": "+value+eol), data...)
+}
+
+// RemoveHeader removes every occurrence of a header field from a raw message,
+// including its continuation lines.
+func RemoveHeader(data []byte, name string) []byte {
+	var res []byte
+	var removing bool
+	var rest = data
+	for len(rest) > 0 {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+		rest = rest[len(line):]
+
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			// end of header, keep the body as is
+			res = append(res, line...)
+			return append(res, rest...)
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			colon := bytes.IndexByte(line, ':')
+			removing = colon >= 0 && strings.EqualFold(string(bytes.TrimSpace(line[:colon])), name)
+		}
+		if !removing {
+			res = append(res, line...)
+		}
+	}
+	return res
+}
+
 func (m *Message) Size() (bytes int, lines int) {
 	return len([]byte(m.Data)), strings.Count(m.Data, "\n")
 }
 
-/*
-func (m *Message) PGPSignature() bool {
+type SignatureStatus int
+
+const (
+	SignatureNone       SignatureStatus = iota // the message is not signed
+	SignatureUnknownKey                        // signed with a key absent from the keyring
+	SignatureInvalid                           // the signature does not verify
+	SignatureValid                             // the signature is valid
+)
+
+// PGPSignature checks the PGP signature of the message against keyring. It
+// returns the fingerprint of the signer if the signature is valid, and the
+// verification status.
+//
+// The signature must cover the given header fields so that it cannot be
+// replayed with other headers: either they are listed in an X-PGP-Sig header,
+// or the message is PGP/MIME signed and repeats them as protected headers in
+// the signed part. A signature that only covers the body counts as no
+// signature, and protected headers that differ from the message header make
+// the signature invalid.
+func (m *Message) PGPSignature(keyring openpgp.KeyRing, headers ...string) (string, SignatureStatus, error) {
+	var signer *openpgp.Entity
+	var err error
+	if len(m.HeaderValues(HeaderPGPSig)) > 0 {
+		signer, err = VerifyPGPControl([]byte(m.Data), keyring, headers...)
+		if _, unsigned := err.(UnsignedHeaderError); unsigned || err == ErrNotSigned {
+			return "", SignatureNone, nil
+		}
+	} else {
+		var signed *Signed
+		signed, err = ReadSigned([]byte(m.Data))
+		if err != nil {
+			return "", SignatureNone, err
+		} else if !signed.IsSigned() || signed.Header == nil {
+			return "", SignatureNone, nil
+		}
+
+		for _, name := range headers {
+			protected := strings.TrimSpace(signed.Header.Get(name))
+			if protected == "" {
+				return "", SignatureNone, nil
+			} else if protected != strings.TrimSpace(m.HeaderValue(name)) {
+				return "", SignatureInvalid, nil
+			}
+		}
+
+		signer, err = signed.Verify(keyring)
+	}
+
+	if err == pgperrors.ErrUnknownIssuer {
+		return "", SignatureUnknownKey, nil
+	} else if err != nil {
+		return "", SignatureInvalid, nil
+	}
+
+	return Fingerprint(signer), SignatureValid, nil
 }
-*/
@@ -0,0 +1,233 @@
+package message
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	netmail "net/mail"
+	"strings"
+
+	gomessage "github.com/emersion/go-message"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+var ErrNotSigned = errors.New("Message is not signed")
+
+// Signed is a message that may carry a PGP signature, either using PGP/MIME
+// (RFC 3156 multipart/signed) or inline (clearsigned text).
+type Signed struct {
+	// Decoded text parts of the signed content. If the message is not signed,
+	// this is empty.
+	Text [][]byte
+	// Public keys attached to the message or sent in an Autocrypt header
+	Keys openpgp.EntityList
+	// Header of the signed part of a PGP/MIME message, where protected header
+	// fields are repeated. nil for other messages.
+	Header netmail.Header
+
+	signed    []byte
+	signature []byte
+	armored   bool
+}
+
+// ReadSigned parses a raw RFC 5322 message and extracts its signed content,
+// signature and attached public keys.
+func ReadSigned(raw []byte) (*Signed, error) {
+	var s = new(Signed)
+
+	msg, err := netmail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, autocrypt := range msg.Header["Autocrypt"] {
+		s.addAutocryptKey(autocrypt)
+	}
+
+	entity, err := gomessage.Read(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	err = walkEntity(entity, func(mediaType string, body []byte) {
+		if mediaType == "application/pgp-keys" || bytes.Contains(body, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+			s.addArmoredKeys(body)
+		}
+		if s.signed == nil && strings.HasPrefix(mediaType, "text/") {
+			s.readClearsigned(body)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err == nil && mediaType == "multipart/signed" && params["protocol"] == "application/pgp-signature" {
+		body, err := ioutil.ReadAll(msg.Body)
+		if err != nil {
+			return nil, err
+		}
+		err = s.readMultipartSigned(body, params["boundary"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// IsSigned tells if the message carries a signature
+func (s *Signed) IsSigned() bool {
+	return s.signature != nil
+}
+
+// Verify checks the signature against the keyring and returns the signer
+func (s *Signed) Verify(keyring openpgp.KeyRing) (*openpgp.Entity, error) {
+	if !s.IsSigned() {
+		return nil, ErrNotSigned
+	}
+	if s.armored {
+		return openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(s.signed), bytes.NewReader(s.signature))
+	}
+	return openpgp.CheckDetachedSignature(keyring, bytes.NewReader(s.signed), bytes.NewReader(s.signature))
+}
+
+// Fingerprint returns the hexadecimal fingerprint of the primary key
+func Fingerprint(e *openpgp.Entity) string {
+	return fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+}
+
+func (s *Signed) addAutocryptKey(header string) {
+	for _, attr := range strings.Split(header, ";") {
+		attr = strings.TrimSpace(attr)
+		if !strings.HasPrefix(attr, "keydata=") {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(attr[len("keydata="):]), ""))
+		if err != nil {
+			continue
+		}
+		keys, err := openpgp.ReadKeyRing(bytes.NewReader(data))
+		if err != nil {
+			continue
+		}
+		s.Keys = append(s.Keys, keys...)
+	}
+}
+
+func (s *Signed) addArmoredKeys(data []byte) {
+	keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	s.Keys = append(s.Keys, keys...)
+}
+
+func (s *Signed) readClearsigned(data []byte) {
+	block, _ := clearsign.Decode(data)
+	if block == nil {
+		return
+	}
+	signature, err := ioutil.ReadAll(block.ArmoredSignature.Body)
+	if err != nil {
+		return
+	}
+	s.signed = block.Bytes
+	s.signature = signature
+	s.armored = false
+	s.Text = [][]byte{block.Plaintext}
+}
+
+func (s *Signed) readMultipartSigned(body []byte, boundary string) error {
+	parts := splitMultipart(body, boundary)
+	if len(parts) < 2 {
+		return fmt.Errorf("multipart/signed message has %d parts, expected 2", len(parts))
+	}
+
+	begin := bytes.Index(parts[1], []byte("-----BEGIN PGP SIGNATURE-----"))
+	if begin < 0 {
+		return fmt.Errorf("multipart/signed message without PGP signature")
+	}
+
+	entity, err := gomessage.Read(bytes.NewReader(parts[0]))
+	if err != nil {
+		return err
+	}
+
+	part, err := netmail.ReadMessage(bytes.NewReader(parts[0]))
+	if err != nil {
+		return err
+	}
+
+	var text [][]byte
+	err = walkEntity(entity, func(mediaType string, body []byte) {
+		if strings.HasPrefix(mediaType, "text/") {
+			text = append(text, body)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	s.signed = parts[0]
+	s.signature = parts[1][begin:]
+	s.armored = true
+	s.Header = part.Header
+	s.Text = text
+	return nil
+}
+
+// splitMultipart returns the raw content of each part of a multipart body,
+// including part headers, with canonical CRLF line endings as required to
+// check signatures.
+func splitMultipart(body []byte, boundary string) [][]byte {
+	var crlf = []byte("\r\n")
+	body = bytes.Replace(body, crlf, []byte("\n"), -1)
+	body = bytes.Replace(body, []byte("\n"), crlf, -1)
+
+	var res [][]byte
+	chunks := bytes.Split(append(crlf, body...), []byte("\r\n--"+boundary))
+	for _, chunk := range chunks[1:] {
+		if bytes.HasPrefix(chunk, []byte("--")) {
+			break
+		}
+		eol := bytes.Index(chunk, crlf)
+		if eol < 0 {
+			break
+		}
+		res = append(res, chunk[eol+len(crlf):])
+	}
+	return res
+}
+
+func walkEntity(e *gomessage.Entity, fn func(mediaType string, body []byte)) error {
+	if mr := e.MultipartReader(); mr != nil {
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			err = walkEntity(part, fn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	mediaType, _, _ := e.Header.ContentType()
+	if mediaType == "" {
+		mediaType = "text/plain"
+	}
+	body, err := ioutil.ReadAll(e.Body)
+	if err != nil {
+		return err
+	}
+	fn(mediaType, body)
+	return nil
+}
@@ -0,0 +1,94 @@
+package message
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+const HeaderPGPSig = "X-PGP-Sig"
+
+// UnsignedHeaderError is returned when a header field that must be signed is
+// not covered by the signature
+type UnsignedHeaderError string
+
+func (e UnsignedHeaderError) Error() string {
+	return "Header " + string(e) + " is not signed"
+}
+
+// VerifyPGPControl checks the X-PGP-Sig signature of a control article, in
+// the format of the PGPControl and pgpverify tools: the signature covers the
+// listed header fields and the body. required lists header fields that must
+// be signed.
+func VerifyPGPControl(raw []byte, keyring openpgp.KeyRing, required ...string) (*openpgp.Entity, error) {
+	raw = bytes.Replace(raw, []byte("\r\n"), []byte("\n"), -1)
+	var header, body = raw, []byte{}
+	if i := bytes.Index(raw, []byte("\n\n")); i >= 0 {
+		header, body = raw[:i+1], raw[i+2:]
+	}
+	fields := rawHeaderFields(header)
+
+	sig := strings.Split(fields[strings.ToLower(HeaderPGPSig)], "\n")
+	first := strings.Fields(sig[0])
+	if len(first) != 2 || len(sig) < 2 {
+		return nil, ErrNotSigned
+	}
+	version, signedHeaders := first[0], strings.Split(first[1], ",")
+
+	for _, name := range required {
+		var signed bool
+		for _, h := range signedHeaders {
+			signed = signed || strings.EqualFold(h, name)
+		}
+		if !signed {
+			return nil, UnsignedHeaderError(name)
+		}
+	}
+
+	var signedText bytes.Buffer
+	signedText.WriteString("X-Signed-Headers: " + first[1] + "\n")
+	for _, name := range signedHeaders {
+		signedText.WriteString(name + ": " + fields[strings.ToLower(name)] + "\n")
+	}
+	signedText.WriteString("\n")
+	signedText.Write(body)
+
+	var armored bytes.Buffer
+	armored.WriteString("-----BEGIN PGP SIGNATURE-----\nVersion: " + version + "\n\n")
+	for _, line := range sig[1:] {
+		armored.WriteString(strings.TrimSpace(line) + "\n")
+	}
+	armored.WriteString("-----END PGP SIGNATURE-----\n")
+
+	return openpgp.CheckArmoredDetachedSignature(keyring, &signedText, &armored)
+}
+
+// rawHeaderFields returns the header fields by lower case name, keeping
+// continuation lines separated by newlines as pgpverify does
+func rawHeaderFields(header []byte) map[string]string {
+	var fields = map[string]string{}
+	var last string
+	for _, line := range strings.Split(strings.TrimSuffix(string(header), "\n"), "\n") {
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			if last != "" {
+				fields[last] += "\n" + line
+			}
+			continue
+		}
+
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			last = ""
+			continue
+		}
+		last = strings.ToLower(strings.TrimSpace(kv[0]))
+		if _, ok := fields[last]; !ok {
+			fields[last] = strings.TrimLeft(kv[1], " \t")
+		} else {
+			// Only the first occurrence is signed
+			last = ""
+		}
+	}
+	return fields
+}
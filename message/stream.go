@@ -0,0 +1,76 @@
+package message
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// ReadHeader reads the header of a raw message, including the empty line
+// separating it from the body. The body can then be read from br.
+func ReadHeader(br *bufio.Reader) ([]byte, error) {
+	var header []byte
+	for {
+		line, err := br.ReadBytes('\n')
+		header = append(header, line...)
+		if len(bytes.TrimRight(line, "\r\n")) == 0 || err == io.EOF {
+			return header, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// HeadReader streams the header of a raw message, without the empty line
+// separating it from the body. Closing it closes r.
+func HeadReader(r io.ReadCloser) io.ReadCloser {
+	return &headReader{br: bufio.NewReader(r), Closer: r}
+}
+
+// BodyReader skips the header of a raw message and streams its body. Closing
+// it closes r.
+func BodyReader(r io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimRight(line, "\r\n")) == 0 || err == io.EOF {
+			break
+		} else if err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{br, r}, nil
+}
+
+type headReader struct {
+	io.Closer
+	br   *bufio.Reader
+	line []byte
+	done bool
+}
+
+func (h *headReader) Read(p []byte) (int, error) {
+	if len(h.line) == 0 {
+		if h.done {
+			return 0, io.EOF
+		}
+		line, err := h.br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			h.done = true
+			return 0, io.EOF
+		}
+		h.done = err == io.EOF
+		h.line = line
+	}
+
+	n := copy(p, h.line)
+	h.line = h.line[n:]
+	return n, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// runPeriodically calls fn at once, then every interval until the context is
+// done. name is used to log when it stops.
+func runPeriodically(ctx context.Context, wg *sync.WaitGroup, name string, interval time.Duration, fn func()) {
+	wg.Add(1)
+	go func() {
+		defer func() {
+			wg.Done()
+			log.Printf("INFO: Stopped %s", name)
+		}()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			fn()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
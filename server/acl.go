@@ -0,0 +1,103 @@
+package server
+
+import (
+	"log"
+
+	"github.com/dustin/go-nntp"
+	"github.com/dustin/go-nntp/server"
+
+	"github.com/mildred/newsweb/articles"
+)
+
+// canRead tells if the session can read groups with the given policy
+func (s *Connection) canRead(policy articles.Policy) bool {
+	return policy != articles.PolicyMembersOnly || s.User != ""
+}
+
+// canPost tells if the session can post to groups with the given policy.
+// validated tells if the sender address was validated before.
+func (s *Connection) canPost(policy articles.Policy, validated bool) bool {
+	switch policy {
+	case articles.PolicyReadOnly:
+		return false
+	case articles.PolicyMembersOnly:
+		return s.User != ""
+	case articles.PolicyValidatedPosts:
+		return s.User != "" || validated
+	default:
+		return true
+	}
+}
+
+func (s *Connection) postingStatus(policy articles.Policy) nntp.PostingStatus {
+	switch {
+	case !s.canPost(policy, true):
+		return nntp.PostingNotPermitted
+	case policy == articles.PolicyModerated:
+		return nntp.PostingModerated
+	default:
+		return nntp.PostingPermitted
+	}
+}
+
+func (s *Connection) convertGroup(grp *articles.Group) *nntp.Group {
+	return &nntp.Group{
+		Name:        grp.Name,
+		Description: grp.Description,
+		Count:       grp.Count,
+		High:        grp.High,
+		Low:         grp.Low,
+		Posting:     s.postingStatus(grp.Policy),
+	}
+}
+
+// checkRead returns an NNTP error if the session cannot read the group
+func (s *Connection) checkRead(groupName string) error {
+	policy, err := s.Server.Articles.GroupPolicy(groupName)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nntpserver.ErrFault
+	} else if !s.canRead(policy) {
+		return nntpserver.ErrNotAuthenticated
+	}
+	return nil
+}
+
+// checkReadAny returns an NNTP error if the session cannot read any of the
+// groups an article was posted to
+func (s *Connection) checkReadAny(groupNames []string) error {
+	var err error = nntpserver.ErrNotAuthenticated
+	for _, name := range groupNames {
+		err = s.checkRead(name)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// checkPost returns an NNTP error if the session cannot post to every group
+func (s *Connection) checkPost(groupNames []string, validated bool) error {
+	for _, name := range groupNames {
+		if s.Server.RequireExistingGroups {
+			exists, err := s.Server.Articles.GroupExists(name)
+			if err != nil {
+				log.Printf("ERROR: %v", err)
+				return nntpserver.ErrFault
+			} else if !exists {
+				log.Printf("INFO: Posting to unknown group %s refused", name)
+				return nntpserver.ErrPostingFailed
+			}
+		}
+
+		policy, err := s.Server.Articles.GroupPolicy(name)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			return nntpserver.ErrFault
+		} else if !s.canPost(policy, validated) {
+			log.Printf("INFO: Posting to %s refused by %s policy", name, policy)
+			return nntpserver.ErrPostingNotPermitted
+		}
+	}
+	return nil
+}
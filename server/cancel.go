@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+
+	"github.com/mildred/newsweb/articles"
+	"github.com/mildred/newsweb/message"
+)
+
+var ErrCancelNotAuthorized = errors.New("Not authorized to cancel or supersede the article")
+
+// controlTarget returns the Message-ID of the article replaced by a cancel
+// control message or a superseding article, or an empty string
+func controlTarget(msg *message.Message) (target string, cancel bool, err error) {
+	if control := strings.Fields(msg.HeaderValue(message.HeaderControl)); len(control) > 0 {
+		if strings.ToLower(control[0]) == "cancel" && len(control) == 2 {
+			return control[1], true, nil
+		}
+		return "", false, fmt.Errorf("Unsupported control message %q", strings.Join(control, " "))
+	}
+
+	return strings.TrimSpace(msg.HeaderValue(message.HeaderSupersedes)), false, nil
+}
+
+// readTarget reads the article replaced by a cancel or a superseding article
+func (s *Server) readTarget(target string) (*message.Message, error) {
+	art, err := s.Articles.GetArticle(target)
+	if err != nil {
+		return nil, err
+	} else if art == nil {
+		return nil, articles.ErrNoArticle
+	}
+	defer art.Close()
+
+	return message.Read(art)
+}
+
+// cancelKeyMatches tells if the article carries a Cancel-Key matching the
+// Cancel-Lock of the article it replaces. Such an article is authorized
+// without a verified sender.
+func (s *Server) cancelKeyMatches(msg *message.Message, target string) bool {
+	if len(msg.HeaderValues(message.HeaderCancelKey)) == 0 {
+		return false
+	}
+
+	targetMsg, err := s.readTarget(target)
+	if err != nil {
+		return false
+	}
+	return message.MatchCancelLock(targetMsg.HeaderValues(message.HeaderCancelLock), msg.HeaderValues(message.HeaderCancelKey))
+}
+
+// replacedArticle returns the article cancelled or superseded by msg, once
+// authorized for email, see authorizeCancel. An unknown superseded article is
+// ignored and msg is then posted as a new article.
+func (s *Server) replacedArticle(email string, msg *message.Message) (target string, cancel bool, err error) {
+	target, cancel, err = controlTarget(msg)
+	if err != nil || target == "" {
+		return target, cancel, err
+	}
+
+	err = s.authorizeCancel(email, msg, target)
+	if err == articles.ErrNoArticle && !cancel {
+		log.Printf("INFO: Superseded article %s is unknown", target)
+		return "", false, nil
+	}
+	return target, cancel, err
+}
+
+// authorizeCancel checks that a cancel or a superseding article comes from
+// the author of the target: email, verified by the caller, is the address of
+// the author, the article is signed with the key of the author, or it carries
+// a matching Cancel-Key. The signature must cover the Message-ID and the
+// header naming the target.
+func (s *Server) authorizeCancel(email string, msg *message.Message, target string) error {
+	targetMsg, err := s.readTarget(target)
+	if err != nil {
+		return err
+	}
+
+	from, _ := targetMsg.Addresses(message.HeaderFrom)
+	if len(from) == 0 {
+		return ErrCancelNotAuthorized
+	} else if strings.EqualFold(email, from[0]) {
+		return nil
+	}
+
+	if message.MatchCancelLock(targetMsg.HeaderValues(message.HeaderCancelLock), msg.HeaderValues(message.HeaderCancelKey)) {
+		return nil
+	}
+
+	var targetHeader = message.HeaderSupersedes
+	if len(msg.HeaderValues(message.HeaderControl)) > 0 {
+		targetHeader = message.HeaderControl
+	}
+
+	key, err := s.Validations.EmailKey(from[0])
+	if err != nil {
+		return err
+	} else if key != nil {
+		_, status, err := msg.PGPSignature(openpgp.EntityList{key}, message.HeaderMessageId, targetHeader)
+		if err != nil {
+			return err
+		} else if status == message.SignatureValid {
+			return nil
+		}
+	}
+
+	return ErrCancelNotAuthorized
+}
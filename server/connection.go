@@ -1,7 +1,9 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"io"
 	"io/ioutil"
 	"log"
@@ -10,40 +12,34 @@ import (
 
 	"github.com/dustin/go-nntp"
 	"github.com/dustin/go-nntp/server"
-	"github.com/paulrosania/go-mail"
 
+	"github.com/mildred/newsweb/accounts"
 	"github.com/mildred/newsweb/articles"
 	"github.com/mildred/newsweb/message"
 )
 
-func convertGroup(grp *articles.Group) *nntp.Group {
-	return &nntp.Group{
-		Name:        grp.Name,
-		Description: grp.Description,
-		Count:       grp.Count,
-		High:        grp.High,
-		Low:         grp.Low,
-		Posting:     nntp.PostingPermitted,
-	}
-}
+// ErrDuplicateMsgId is returned when posting an article whose Message-ID is
+// already known
+var ErrDuplicateMsgId = &nntpserver.NNTPError{Code: 441, Msg: "Duplicate Message-ID"}
 
 type Connection struct {
 	Server *Server
+	// E-mail address of the authenticated user, empty for anonymous sessions
+	User string
 }
 
+var errMaxGroups = errors.New("Maximum number of groups reached")
+
 func (s *Connection) ListGroups(max int) (res []*nntp.Group, err error) {
-	grps, err := s.Server.Articles.ListGroups()
-	if err != nil {
-		log.Printf("FATAL: %v", err)
-		return nil, nntpserver.ErrFault
-	}
-	for _, grp := range grps {
+	err = s.ListActive("", func(grp *nntp.Group) error {
 		if max >= 0 && len(res) >= max {
-			log.Printf("DEBUG: max groups of %d reached", max)
-			break
+			return errMaxGroups
 		}
-		log.Printf("DEBUG: list group %s", grp.Name)
-		res = append(res, convertGroup(grp))
+		res = append(res, grp)
+		return nil
+	})
+	if err != nil && err != errMaxGroups {
+		return nil, err
 	}
 	return res, nil
 }
@@ -53,11 +49,19 @@ func (s *Connection) GetGroup(name string) (*nntp.Group, error) {
 	if err != nil {
 		log.Printf("ERROR: %v", err)
 		return nil, nntpserver.ErrNoSuchGroup
+	} else if !s.canRead(grp.Policy) {
+		return nil, nntpserver.ErrNotAuthenticated
 	}
-	return convertGroup(grp), nil
+	return s.convertGroup(grp), nil
 }
 
 func (s *Connection) GetArticleNum(group *nntp.Group, num int64) (io.ReadCloser, string, error) {
+	if group == nil {
+		return nil, "0", nntpserver.ErrNoGroupSelected
+	} else if err := s.checkRead(group.Name); err != nil {
+		return nil, "0", err
+	}
+
 	art, msgId, err := s.Server.Articles.GetArticleNum(group.Name, num)
 	if err == articles.ErrNoGroup {
 		return nil, "0", nntpserver.ErrNoSuchGroup
@@ -75,11 +79,16 @@ func (s *Connection) GetArticleNum(group *nntp.Group, num int64) (io.ReadCloser,
 	return art, msgId, nil
 }
 
+// GetArticleMsgId finds an article in any group. The article number is only
+// returned if the article is in the selected group, 0 otherwise.
 func (s *Connection) GetArticleMsgId(group *nntp.Group, id string) (io.ReadCloser, int64, error) {
-	art, num, err := s.Server.Articles.GetArticleMsgId(group.Name, id)
-	if err == articles.ErrNoGroup {
-		return nil, -1, nntpserver.ErrNoSuchGroup
-	} else if err != nil {
+	num, err := s.StatArticleMsgId(group, id)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	art, err := s.Server.Articles.GetArticle(id)
+	if err != nil {
 		log.Printf("ERROR: %v", err)
 		return nil, -1, nntpserver.ErrFault
 	} else if art == nil {
@@ -90,44 +99,34 @@ func (s *Connection) GetArticleMsgId(group *nntp.Group, id string) (io.ReadClose
 }
 
 func (s *Connection) GetArticles(group *nntp.Group, from, to int64) ([]nntpserver.NumberedArticle, error) {
-	var res []nntpserver.NumberedArticle
-	for num := from; num <= to; num++ {
-		art, _, err := s.Server.Articles.GetArticleNum(group.Name, num)
-		if err == articles.ErrNoGroup {
-			return nil, nntpserver.ErrNoSuchGroup
-		} else if err != nil {
-			log.Printf("ERROR: %v", err)
-			return nil, nntpserver.ErrFault
-		}
-		if art == nil {
-			continue
-		}
-		defer art.Close()
-
-		artBytes, err := ioutil.ReadAll(art)
-		if err != nil {
-			log.Printf("ERROR: %v", err)
-			return nil, nntpserver.ErrFault
-		}
+	if err := s.checkRead(group.Name); err != nil {
+		return nil, err
+	}
 
-		msg, err := mail.ReadMessage(string(artBytes))
-		if err != nil {
-			log.Printf("ERROR: %v", err)
-			return nil, nntpserver.ErrFault
-		}
+	overviews, err := s.Server.Articles.GetOverview(group.Name, from, to)
+	if err == articles.ErrNoGroup {
+		return nil, nntpserver.ErrNoSuchGroup
+	} else if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, nntpserver.ErrFault
+	}
 
+	var res []nntpserver.NumberedArticle
+	for _, ov := range overviews {
 		a := nntpserver.NumberedArticle{
-			Num: num,
+			Num: ov.Num,
 			Article: &nntp.Article{
 				Body:   nil,
-				Bytes:  msg.RFC822Size,
-				Lines:  bytes.Count(artBytes, []byte("\n")),
+				Bytes:  ov.Bytes,
+				Lines:  ov.Lines,
 				Header: textproto.MIMEHeader{},
 			},
 		}
-		for _, header := range msg.Header.Fields {
-			a.Article.Header.Add(header.Name(), header.Value())
-		}
+		a.Article.Header.Set("Subject", ov.Subject)
+		a.Article.Header.Set("From", ov.From)
+		a.Article.Header.Set("Date", ov.Date)
+		a.Article.Header.Set("Message-Id", ov.MsgId)
+		a.Article.Header.Set("References", ov.References)
 		res = append(res, a)
 	}
 	return res, nil
@@ -140,65 +139,194 @@ func (s *Connection) Authorized() bool {
 // Authenticate and optionally swap out the backend for this session.
 // You may return nil to continue using the same backend.
 func (s *Connection) Authenticate(user, pass string) (nntpserver.Backend, error) {
-	return nil, nil
+	err := s.Server.Accounts.CheckPassword(user, pass)
+	if err == accounts.ErrNoAccount {
+		log.Printf("INFO: No account for %s", user)
+		return nil, nntpserver.ErrAuthRejected
+	} else if err == accounts.ErrBadPassword {
+		log.Printf("INFO: Invalid password for %s", user)
+		return nil, nntpserver.ErrAuthRejected
+	} else if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, nntpserver.ErrFault
+	}
+
+	log.Printf("INFO: Authenticated %s", user)
+	return &Connection{Server: s.Server, User: user}, nil
 }
 
 func (s *Connection) AllowPost() bool {
 	return true
 }
 
+// Post reads the article header, then either streams the body straight to the
+// store when the sender is authenticated by the session or a trust key, or
+// reads the article in memory to check its signature or queue it.
 func (s *Connection) Post(article io.Reader) error {
-	var buffer = new(bytes.Buffer)
-	_, err := io.Copy(buffer, article)
+	br := bufio.NewReader(article)
+	header, err := message.ReadHeader(br)
 	if err != nil {
 		log.Printf("ERROR: %v", err)
 		return nntpserver.ErrPostingFailed
 	}
 
-	msg, err := mail.ReadMessage(string(buffer.Bytes()))
+	msg, err := message.ReadBytes(header)
 	if err != nil {
 		log.Printf("ERROR: %v", err)
 		return nntpserver.ErrPostingFailed
 	}
 
-	from := msg.Header.Addresses(mail.FromFieldName)
-	fromAddr := message.AddressString(from[0])
-	token, err := s.Server.Validations.GenValidationToken(fromAddr)
+	from, _ := msg.Addresses(message.HeaderFrom)
+	if len(from) == 0 {
+		log.Print("ERROR: From header absent")
+		return nntpserver.ErrPostingFailed
+	}
+	fromAddr := from[0]
+
+	msgIds := msg.HeaderValues(message.HeaderMessageId)
+	if len(msgIds) > 1 {
+		log.Print("ERROR: Duplicate Message-Id")
+		return nntpserver.ErrPostingFailed
+	}
+	var msgId string
+	if len(msgIds) > 0 {
+		msgId = strings.TrimSpace(msgIds[0])
+	} else {
+		msgId = s.Server.genMsgId()
+		header = message.AddHeader(header, message.HeaderMessageId, msgId)
+	}
+	trustKey := strings.TrimSpace(msg.HeaderValue(HeaderTrustKey))
+	header = message.RemoveHeader(header, HeaderTrustKey)
+	// Only moderators can approve articles, through publish
+	header = message.RemoveHeader(header, message.HeaderApproved)
+
+	// data is the whole article, read only when needed
+	var data []byte
+	readArticle := func() error {
+		body, err := ioutil.ReadAll(br)
+		if err != nil {
+			return err
+		}
+		data = append(append([]byte(nil), header...), body...)
+		msg, err = message.ReadBytes(data)
+		return err
+	}
+
+	known, err := s.Server.Articles.HasMsgId(msgId)
 	if err != nil {
 		log.Printf("ERROR: %v", err)
 		return nntpserver.ErrPostingFailed
+	} else if known {
+		log.Printf("INFO: Refused duplicate article %s", msgId)
+		return ErrDuplicateMsgId
 	}
 
-	validationMail := s.Server.Mailer.GenValidationMail(fromAddr, token)
-	err = s.Server.Mailer.Send(validationMail, fromAddr)
+	if isGroupControl(msg) {
+		err = readArticle()
+		if err == nil {
+			err = s.Server.groupControl(msg, msgId, data)
+		}
+		if err == ErrControlNotAuthorized {
+			return nntpserver.ErrPostingNotPermitted
+		} else if err != nil {
+			log.Printf("ERROR: %v", err)
+			return nntpserver.ErrPostingFailed
+		}
+		return nil
+	}
+
+	newsgroups := msg.HeaderValues(message.HeaderNewsgroups)
+	if len(newsgroups) != 1 {
+		log.Printf("ERROR: Expected one Newsgroups header, got %d", len(newsgroups))
+		return nntpserver.ErrPostingFailed
+	}
+	groups, err := articles.ParseNewsgroups(newsgroups[0])
 	if err != nil {
 		log.Printf("ERROR: %v", err)
 		return nntpserver.ErrPostingFailed
+	} else if s.Server.MaxCrossPost > 0 && len(groups) > s.Server.MaxCrossPost {
+		log.Printf("INFO: Refused article %s cross-posted to %d groups", msgId, len(groups))
+		return nntpserver.ErrPostingFailed
 	}
 
-	var msgId string
-	var groups []string
-	for _, hdr := range msg.Header.Fields {
-		if strings.ToLower(hdr.Name()) == "newsgroups" {
-			groups = append(groups, hdr.Value())
-		} else if strings.ToLower(hdr.Name()) == "message-id" {
-			if msgId != "" {
-				log.Print("ERROR: Duplicate Message-Id")
-				return nntpserver.ErrPostingFailed
-			}
-			msgId = hdr.Value()
+	trusted, err := s.Server.Validations.IsTrusted(fromAddr, trustKey)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nntpserver.ErrPostingFailed
+	}
+
+	// The signature only matters if the sender is not authenticated otherwise
+	var signed bool
+	if s.User == "" && !trusted {
+		err = readArticle()
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			return nntpserver.ErrPostingFailed
+		}
+
+		signed, err = s.Server.signedByKnownKey(msg, fromAddr)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			return nntpserver.ErrPostingFailed
 		}
 	}
-	if len(groups) == 0 {
-		log.Print("ERROR: Newsgroup header absent")
+
+	validatedAt, err := s.Server.Validations.ValidatedAt(fromAddr)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nntpserver.ErrPostingFailed
+	}
+
+	err = s.checkPost(groups, signed || trusted || !validatedAt.IsZero())
+	if err != nil {
+		return err
+	}
+
+	if s.User != "" && !strings.EqualFold(fromAddr, s.User) {
+		log.Printf("ERROR: %s cannot post as %s", s.User, fromAddr)
+		return nntpserver.ErrPostingFailed
+	}
+
+	target, _, err := controlTarget(msg)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
 		return nntpserver.ErrPostingFailed
 	}
+	cancelKey := target != "" && s.Server.cancelKeyMatches(msg, target)
 
-	err = s.Server.Articles.Post(groups, msgId, buffer.Bytes())
+	moderated, err := s.Server.moderatedGroups(groups)
 	if err != nil {
 		log.Printf("ERROR: %v", err)
 		return nntpserver.ErrPostingFailed
 	}
 
+	// The sender is authenticated and nothing needs the whole article
+	stream := data == nil && target == "" && len(moderated) == 0
+	if !stream && data == nil {
+		err = readArticle()
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			return nntpserver.ErrPostingFailed
+		}
+	}
+
+	if stream {
+		err = s.Server.Articles.PostReader(groups, msgId, io.MultiReader(bytes.NewReader(header), br))
+	} else if s.User != "" || signed || trusted || cancelKey {
+		err = s.Server.publish(fromAddr, s.User != "" || signed, groups, msgId, data)
+	} else {
+		err = s.Server.requestValidation(fromAddr, groups, msgId, data)
+	}
+	if err == articles.ErrDuplicateMsgId {
+		log.Printf("INFO: Refused duplicate article %s", msgId)
+		return ErrDuplicateMsgId
+	} else if err == ErrCancelNotAuthorized || err == articles.ErrNoArticle {
+		log.Printf("INFO: Refused article %s replacing %s: %v", msgId, target, err)
+		return nntpserver.ErrPostingFailed
+	} else if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nntpserver.ErrPostingFailed
+	}
+
 	return nil
 }
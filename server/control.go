@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+
+	"github.com/mildred/newsweb/articles"
+	"github.com/mildred/newsweb/message"
+)
+
+// HeaderAudit names the control article filed in the audit group and describes
+// the action taken
+const HeaderAudit = "X-Audit"
+
+var ErrControlNotAuthorized = errors.New("Control article not signed by a trusted administrator")
+
+// isGroupControl tells if the article is a newgroup or rmgroup control
+// article
+func isGroupControl(msg *message.Message) bool {
+	control := strings.Fields(msg.HeaderValue(message.HeaderControl))
+	if len(control) == 0 {
+		return false
+	}
+	cmd := strings.ToLower(control[0])
+	return cmd == "newgroup" || cmd == "rmgroup"
+}
+
+// controlKeyring reads the keyring of the administrators trusted to send group
+// control articles. It is read for each article so that it can be changed
+// without restarting the server.
+func (s *Server) controlKeyring() (openpgp.EntityList, error) {
+	if s.ControlKeyring == "" {
+		return nil, errors.New("No control keyring configured")
+	}
+
+	f, err := os.Open(s.ControlKeyring)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return openpgp.ReadArmoredKeyRing(f)
+}
+
+// verifyControl returns the administrator who signed a control article with
+// an X-PGP-Sig header covering at least its Control and Message-ID headers
+func (s *Server) verifyControl(data []byte) (*openpgp.Entity, error) {
+	keyring, err := s.controlKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	return message.VerifyPGPControl(data, keyring, message.HeaderControl, message.HeaderMessageId)
+}
+
+// groupControl applies a newgroup or rmgroup control article signed by a
+// trusted administrator, and files it in the audit group.
+func (s *Server) groupControl(msg *message.Message, msgId string, data []byte) error {
+	control := strings.Fields(msg.HeaderValue(message.HeaderControl))
+	if len(control) < 2 {
+		return fmt.Errorf("Invalid control %q", strings.Join(control, " "))
+	}
+	cmd, name := strings.ToLower(control[0]), control[1]
+
+	signer, err := s.verifyControl(data)
+	if err != nil {
+		log.Printf("INFO: Refused control %s %s: %v", cmd, name, err)
+		return ErrControlNotAuthorized
+	}
+	admin := message.Fingerprint(signer)
+	if uid := primaryIdentity(signer); uid != "" {
+		admin = uid + " " + admin
+	}
+
+	var c = articles.GroupControl{Group: name}
+	switch cmd {
+	case "newgroup":
+		c.Moderated = len(control) > 2 && strings.ToLower(control[2]) == "moderated"
+		c.Description = newgroupDescription(data, name)
+	case "rmgroup":
+		c.Remove = true
+	}
+
+	existed, err := s.Articles.ApplyGroupControl(msgId, c)
+	if err != nil {
+		return err
+	}
+
+	var action string
+	switch {
+	case c.Remove:
+		action = "removed " + name
+	case existed:
+		action = "changed " + name
+	default:
+		action = "created " + name
+	}
+	if c.Moderated {
+		action += " (moderated)"
+	}
+
+	log.Printf("INFO: Control %s by %s: %s", cmd, admin, action)
+	return s.audit(msgId, data, action+" by "+admin)
+}
+
+// primaryIdentity returns the user id flagged as primary, or the first one in
+// name order, so that the same key is always described the same way
+func primaryIdentity(e *openpgp.Entity) string {
+	var names []string
+	for name, id := range e.Identities {
+		if id.SelfSignature != nil && id.SelfSignature.IsPrimaryId != nil && *id.SelfSignature.IsPrimaryId {
+			return name
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// newgroupDescription reads the description of a group from the body of a
+// newgroup control article, as specified by RFC 5537 section 5.2.1: the line
+// following "For your newsgroups file:" holds the group name and description.
+func newgroupDescription(data []byte, name string) string {
+	var body []byte
+	if i := bytes.Index(data, []byte("\n\n")); i >= 0 {
+		body = data[i+2:]
+	} else if i := bytes.Index(data, []byte("\r\n\r\n")); i >= 0 {
+		body = data[i+4:]
+	}
+
+	var descr string
+	var found bool
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.EqualFold(line, "For your newsgroups file:") {
+			found = true
+		} else if found {
+			if fields := strings.Fields(line); len(fields) > 0 && fields[0] == name {
+				descr = strings.TrimSpace(line[len(name):])
+			}
+			break
+		}
+	}
+	return strings.TrimSpace(strings.TrimSuffix(descr, "(Moderated)"))
+}
+
+// audit files a control article in the audit group, which is created read-only
+// if needed. The control article Message-ID is already recorded when it was
+// applied, the audit article gets a new one and names the control article in
+// its X-Audit header.
+func (s *Server) audit(msgId string, data []byte, action string) error {
+	if s.AuditGroup == "" {
+		return nil
+	}
+
+	exists, err := s.Articles.GroupExists(s.AuditGroup)
+	if err != nil {
+		return err
+	} else if !exists {
+		err = s.Articles.SetGroupPolicy(s.AuditGroup, articles.PolicyReadOnly)
+		if err != nil {
+			return err
+		}
+	}
+
+	auditId := s.genMsgId()
+	data = message.RemoveHeader(message.RemoveHeader(data, HeaderAudit), message.HeaderMessageId)
+	data = message.AddHeader(data, message.HeaderMessageId, auditId)
+	data = message.AddHeader(data, HeaderAudit, msgId+" "+action)
+	return s.Articles.PostReader([]string{s.AuditGroup}, auditId, bytes.NewReader(data))
+}
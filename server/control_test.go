@@ -0,0 +1,37 @@
+package server
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+func TestPrimaryIdentity(t *testing.T) {
+	var primary = true
+	var tests = []struct {
+		name       string
+		identities map[string]*openpgp.Identity
+		expected   string
+	}{
+		{"no identity", map[string]*openpgp.Identity{}, ""},
+		{"name order", map[string]*openpgp.Identity{
+			"Zed <z@example.org>":   {},
+			"Alice <a@example.org>": {},
+			"Bob <b@example.org>":   {},
+		}, "Alice <a@example.org>"},
+		{"primary flag", map[string]*openpgp.Identity{
+			"Alice <a@example.org>": {SelfSignature: &packet.Signature{}},
+			"Bob <b@example.org>":   {SelfSignature: &packet.Signature{IsPrimaryId: &primary}},
+		}, "Bob <b@example.org>"},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 10; i++ {
+			actual := primaryIdentity(&openpgp.Entity{Identities: test.identities})
+			if actual != test.expected {
+				t.Fatalf("%s: expected %q, got %q", test.name, test.expected, actual)
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"log"
+	"strings"
+
+	"github.com/dustin/go-nntp"
+	"github.com/dustin/go-nntp/server"
+
+	"github.com/mildred/newsweb/articles"
+)
+
+// ListActive calls fn for each readable group matching the wildmat for LIST
+// ACTIVE. An empty wildmat matches every group. An error returned by fn stops
+// the listing and is returned as is.
+func (s *Connection) ListActive(wildmat string, fn func(*nntp.Group) error) error {
+	var fnErr error
+	err := s.Server.Articles.EachGroup(wildmat, func(grp *articles.Group) error {
+		if !s.canRead(grp.Policy) {
+			return nil
+		}
+		fnErr = fn(s.convertGroup(grp))
+		return fnErr
+	})
+	if err != nil && err == fnErr {
+		return err
+	} else if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nntpserver.ErrFault
+	}
+	return nil
+}
+
+// ListNewsgroups calls fn with the name and description of the readable groups
+// matching the wildmat for LIST NEWSGROUPS
+func (s *Connection) ListNewsgroups(wildmat string, fn func(name, description string) error) error {
+	return s.ListActive(wildmat, func(grp *nntp.Group) error {
+		return fn(grp.Name, grp.Description)
+	})
+}
+
+// ListOverviewFmt returns the overview fields for LIST OVERVIEW.FMT
+func (s *Connection) ListOverviewFmt() []string {
+	return articles.OverviewFields
+}
+
+// ListHeaders returns the fields that can be retrieved from the overview for
+// LIST HEADERS, header names without their trailing colon
+func (s *Connection) ListHeaders() []string {
+	var res []string
+	for _, field := range articles.OverviewFields {
+		res = append(res, strings.TrimSuffix(field, ":"))
+	}
+	return res
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+
+	"github.com/mildred/newsweb/articles"
+	"github.com/mildred/newsweb/message"
+)
+
+const TokenSize = 32
+
+// publish posts an article from a verified sender. Articles to moderated
+// groups are queued for moderation unless the sender moderates all of them and
+// is authenticated, by the session or a signature. Cancels and superseding
+// articles must come from the author of the article they replace, cancels are
+// applied at once.
+func (s *Server) publish(email string, authenticated bool, groups []string, msgId string, data []byte) error {
+	msg, err := message.ReadBytes(data)
+	if err != nil {
+		return err
+	}
+
+	target, cancel, err := s.replacedArticle(email, msg)
+	if err != nil {
+		return err
+	}
+
+	if cancel {
+		err = s.Articles.Cancel(target)
+		if err != nil {
+			return err
+		}
+		log.Printf("INFO: Article %s cancelled by %s", target, email)
+		return nil
+	}
+
+	moderated, err := s.moderatedGroups(groups)
+	if err != nil {
+		return err
+	}
+
+	if len(moderated) > 0 {
+		for _, grp := range moderated {
+			if !authenticated || !contains(grp.Moderators, email) {
+				return s.requestModeration(email, groups, moderated, msgId, data)
+			}
+		}
+		data = message.AddHeader(data, message.HeaderApproved, email)
+	}
+
+	return s.store(groups, msgId, target, data)
+}
+
+// store posts an article, replacing the article target if set
+func (s *Server) store(groups []string, msgId, target string, data []byte) error {
+	if target == "" {
+		return s.Articles.Post(groups, msgId, data)
+	}
+
+	err := s.Articles.Supersede(target, groups, msgId, data)
+	if err != nil {
+		return err
+	}
+	log.Printf("INFO: Article %s superseded by %s", target, msgId)
+	return nil
+}
+
+func (s *Server) moderatedGroups(groupNames []string) (res []*articles.Group, err error) {
+	for _, name := range groupNames {
+		grp, err := s.Articles.GetGroup(name)
+		if err == articles.ErrNoGroup {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if grp.Policy == articles.PolicyModerated {
+			res = append(res, grp)
+		}
+	}
+	return res, nil
+}
+
+func moderatorsOf(groups []*articles.Group) (res []string) {
+	for _, grp := range groups {
+		for _, moderator := range grp.Moderators {
+			if !contains(res, moderator) {
+				res = append(res, moderator)
+			}
+		}
+	}
+	return res
+}
+
+func (s *Server) requestModeration(email string, groups []string, moderated []*articles.Group, msgId string, data []byte) error {
+	moderators := moderatorsOf(moderated)
+	if len(moderators) == 0 {
+		return fmt.Errorf("No moderator for groups %v", groups)
+	}
+
+	m := &articles.Moderation{
+		Pending: articles.Pending{
+			Token:  genToken(),
+			Email:  email,
+			Groups: groups,
+			MsgId:  msgId,
+			Data:   data,
+			Expire: time.Now().Add(s.ModerationExpire),
+		},
+		ApproveToken: genToken(),
+		RejectToken:  genToken(),
+	}
+	err := s.Articles.AddModeration(m)
+	if err != nil {
+		return err
+	}
+
+	mail := s.Mailer.GenModerationMail(moderators, groups, m.ApproveToken, m.RejectToken, data)
+	err = s.Mailer.Send(mail, moderators...)
+	if err != nil {
+		if err := s.Articles.RemoveModeration(m.Token); err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+		return err
+	}
+
+	log.Printf("INFO: Article %s from %s pending moderation", msgId, email)
+	return nil
+}
+
+// ModeratorKeys returns the PGP keys of the moderators that can approve or
+// reject the article identified by token.
+func (s *Server) ModeratorKeys(token string) (keyring openpgp.EntityList, err error) {
+	m, err := s.Articles.GetModerationByToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	moderated, err := s.moderatedGroups(m.Groups)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, moderator := range moderatorsOf(moderated) {
+		key, err := s.Validations.EmailKey(moderator)
+		if err != nil {
+			return nil, err
+		} else if key != nil {
+			keyring = append(keyring, key)
+		}
+	}
+	return keyring, nil
+}
+
+// ReceivedModeration is called by the mailer when a moderator signed with key
+// approved or rejected an article.
+func (s *Server) ReceivedModeration(token string, approve bool, key *openpgp.Entity) error {
+	m, err := s.Articles.GetModerationByToken(token)
+	if err != nil {
+		return err
+	}
+
+	if (approve && token != m.ApproveToken) || (!approve && token != m.RejectToken) {
+		return fmt.Errorf("Moderation token does not match the requested action")
+	}
+
+	moderator, err := s.moderatorForKey(m.Groups, key)
+	if err != nil {
+		return err
+	}
+
+	if approve {
+		var msg *message.Message
+		var target string
+		msg, err = message.ReadBytes(m.Data)
+		if err == nil {
+			target, _, err = s.replacedArticle(m.Email, msg)
+		}
+		if err == nil {
+			err = s.store(m.Groups, m.MsgId, target, message.AddHeader(m.Data, message.HeaderApproved, moderator))
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("INFO: Article %s approved by %s", m.MsgId, moderator)
+	} else {
+		err = s.Mailer.Send(s.Mailer.GenRejectionMail(m.Email, m.MsgId, m.Groups), m.Email)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+		log.Printf("INFO: Article %s rejected by %s", m.MsgId, moderator)
+	}
+
+	return s.Articles.RemoveModeration(m.Token)
+}
+
+func (s *Server) moderatorForKey(groups []string, key *openpgp.Entity) (string, error) {
+	moderated, err := s.moderatedGroups(groups)
+	if err != nil {
+		return "", err
+	}
+
+	fpr := message.Fingerprint(key)
+	for _, moderator := range moderatorsOf(moderated) {
+		modKey, err := s.Validations.EmailKey(moderator)
+		if err != nil {
+			return "", err
+		} else if modKey != nil && message.Fingerprint(modKey) == fpr {
+			return moderator, nil
+		}
+	}
+	return "", fmt.Errorf("Key %s does not belong to a moderator of %v", fpr, groups)
+}
+
+func contains(list []string, item string) bool {
+	for _, elem := range list {
+		if elem == item {
+			return true
+		}
+	}
+	return false
+}
+
+// genMsgId generates a unique Message-ID for articles posted without one
+func (s *Server) genMsgId() string {
+	return "<" + genToken() + "@" + s.Domain + ">"
+}
+
+func genToken() string {
+	var data = make([]byte, TokenSize)
+	_, _ = rand.Read(data)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
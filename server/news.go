@@ -0,0 +1,54 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/dustin/go-nntp"
+	"github.com/dustin/go-nntp/server"
+
+	"github.com/mildred/newsweb/articles"
+)
+
+// NewGroups lists the readable groups created since the given time
+func (s *Connection) NewGroups(since time.Time) (res []*nntp.Group, err error) {
+	grps, err := s.Server.Articles.NewGroups(since)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, nntpserver.ErrFault
+	}
+
+	for _, grp := range grps {
+		if s.canRead(grp.Policy) {
+			res = append(res, s.convertGroup(grp))
+		}
+	}
+	return res, nil
+}
+
+// NewNews lists the Message-IDs of the articles that arrived since the given
+// time in readable groups matching the wildmat
+func (s *Connection) NewNews(wildmat string, since time.Time) ([]string, error) {
+	grps, err := s.Server.Articles.ListGroups()
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, nntpserver.ErrFault
+	}
+
+	var names []string
+	for _, grp := range grps {
+		if s.canRead(grp.Policy) && articles.MatchWildmat(wildmat, grp.Name) {
+			names = append(names, grp.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	msgIds, err := s.Server.Articles.NewNews(since, names)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, nntpserver.ErrFault
+	}
+	return msgIds, nil
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"log"
+
+	"golang.org/x/crypto/openpgp"
+
+	"github.com/mildred/newsweb/articles"
+)
+
+// EmailKey returns the PGP key bound to the e-mail address, if any
+func (s *Server) EmailKey(email string) (*openpgp.Entity, error) {
+	return s.Validations.EmailKey(email)
+}
+
+// ReceivedEmailToken is called by the mailer when a validation reply signed
+// by key comes back. It validates the token and sets the password or publishes
+// the article that was waiting for it.
+func (s *Server) ReceivedEmailToken(email, token string, key *openpgp.Entity) error {
+	err := s.Validations.ReceivedEmailToken(email, token, key)
+	if err != nil {
+		return err
+	}
+
+	confirmed, err := s.Accounts.ConfirmPassword(email, token)
+	if err != nil {
+		return err
+	} else if confirmed {
+		log.Printf("INFO: Password set for %s", email)
+		return nil
+	}
+
+	p, err := s.Articles.GetPending(token)
+	if err == articles.ErrNoPending {
+		log.Printf("INFO: No pending article for token %s", token)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if p.Email != email {
+		return fmt.Errorf("pending article for token %s was sent by %s, not %s", token, p.Email, email)
+	}
+
+	err = s.publish(p.Email, false, p.Groups, p.MsgId, p.Data)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("INFO: Validated pending article %s from %s", p.MsgId, email)
+	return s.Articles.RemovePending(token)
+}
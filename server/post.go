@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	netmail "net/mail"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+
+	"github.com/mildred/newsweb/articles"
+	"github.com/mildred/newsweb/message"
+)
+
+var ErrInvalidAddress = errors.New("Invalid e-mail address")
+
+// HeaderTrustKey carries the token of the last validation of the sender, to
+// publish without a new validation during the trust window. It is removed
+// before the article is stored.
+const HeaderTrustKey = "X-Trust-Key"
+
+// signedByKnownKey tells if the article is signed with the PGP key bound to
+// the sender e-mail address. The signature must cover the From, Newsgroups and
+// Message-ID header fields, so a signed body cannot be posted again with other
+// headers. An article carrying a signature that does not verify against the
+// bound key is rejected.
+func (s *Server) signedByKnownKey(msg *message.Message, email string) (bool, error) {
+	key, err := s.Validations.EmailKey(email)
+	if err != nil {
+		return false, err
+	} else if key == nil {
+		return false, nil
+	}
+
+	fpr, status, err := msg.PGPSignature(openpgp.EntityList{key}, message.HeaderFrom, message.HeaderNewsgroups, message.HeaderMessageId)
+	if err != nil {
+		return false, err
+	}
+
+	switch status {
+	case message.SignatureValid:
+		log.Printf("INFO: Article from %s signed with known key %s", email, fpr)
+		return true, nil
+	case message.SignatureInvalid:
+		return false, fmt.Errorf("Invalid PGP signature on article from %s", email)
+	default:
+		return false, nil
+	}
+}
+
+// requestValidation queues the article until the sender confirms the e-mail
+// address by replying to the validation mail.
+func (s *Server) requestValidation(email string, groups []string, msgId string, data []byte) error {
+	token, err := s.Validations.GenValidationToken(email)
+	if err != nil {
+		return err
+	}
+
+	err = s.Articles.AddPending(&articles.Pending{
+		Token:  token,
+		Email:  email,
+		Groups: groups,
+		MsgId:  msgId,
+		Data:   data,
+		Expire: time.Now().Add(s.PendingExpire),
+	})
+	if err != nil {
+		return err
+	}
+
+	validationMail := s.Mailer.GenValidationMail(email, token)
+	err = s.Mailer.Send(validationMail, email)
+	if err != nil {
+		if err := s.Articles.RemovePending(token); err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+		return err
+	}
+
+	log.Printf("INFO: Article %s from %s pending validation", msgId, email)
+	return nil
+}
+
+// RequestPassword records the password and asks the address owner to confirm
+// it by e-mail. The address must be a bare e-mail address, and requests for
+// the same address are limited by the accounts request interval.
+func (s *Server) RequestPassword(email, pass string) error {
+	addr, err := netmail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return ErrInvalidAddress
+	}
+
+	token, err := s.Validations.GenValidationToken(email)
+	if err != nil {
+		return err
+	}
+
+	err = s.Accounts.RequestPassword(email, pass, token)
+	if err != nil {
+		return err
+	}
+
+	err = s.Mailer.Send(s.Mailer.GenPasswordMail(email, token), email)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("INFO: Password for %s pending validation", email)
+	return nil
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"io"
+	"log"
+	"time"
+
+	"github.com/dustin/go-nntp"
+	"github.com/dustin/go-nntp/server"
+
+	"github.com/mildred/newsweb/articles"
+	"github.com/mildred/newsweb/message"
+)
+
+var (
+	ErrNoNextArticle = &nntpserver.NNTPError{Code: 421, Msg: "No next article in this group"}
+	ErrNoPrevArticle = &nntpserver.NNTPError{Code: 422, Msg: "No previous article in this group"}
+)
+
+// ReaderBackend is the backend of the NNTP sessions. It supports every RFC
+// 3977 reading command: STAT, HEAD, BODY, NEXT, LAST, LISTGROUP, NEWGROUPS,
+// NEWNEWS and the LIST variants in addition to the commands of
+// nntpserver.Backend. Message-ID forms accept a nil group.
+type ReaderBackend interface {
+	nntpserver.Backend
+	StatArticleNum(group *nntp.Group, num int64) (string, error)
+	StatArticleMsgId(group *nntp.Group, id string) (int64, error)
+	GetHeadNum(group *nntp.Group, num int64) (io.ReadCloser, string, error)
+	GetHeadMsgId(group *nntp.Group, id string) (io.ReadCloser, int64, error)
+	GetBodyNum(group *nntp.Group, num int64) (io.ReadCloser, string, error)
+	GetBodyMsgId(group *nntp.Group, id string) (io.ReadCloser, int64, error)
+	NextArticle(group *nntp.Group, num int64) (int64, string, error)
+	LastArticle(group *nntp.Group, num int64) (int64, string, error)
+	ListArticles(group *nntp.Group, from, to int64) ([]int64, error)
+	NewGroups(since time.Time) ([]*nntp.Group, error)
+	NewNews(wildmat string, since time.Time) ([]string, error)
+	ListActive(wildmat string, fn func(*nntp.Group) error) error
+	ListNewsgroups(wildmat string, fn func(name, description string) error) error
+	ListOverviewFmt() []string
+	ListHeaders() []string
+}
+
+var _ ReaderBackend = (*Connection)(nil)
+
+func (s *Connection) StatArticleNum(group *nntp.Group, num int64) (string, error) {
+	if group == nil {
+		return "0", nntpserver.ErrNoGroupSelected
+	} else if err := s.checkRead(group.Name); err != nil {
+		return "0", err
+	}
+
+	msgId, found, err := s.Server.Articles.ArticleMsgId(group.Name, num)
+	if err == articles.ErrNoGroup {
+		return "0", nntpserver.ErrNoSuchGroup
+	} else if err != nil {
+		log.Printf("ERROR: %v", err)
+		return "0", nntpserver.ErrFault
+	} else if !found {
+		return "0", nntpserver.ErrInvalidArticleNumber
+	}
+
+	if msgId == "" {
+		msgId = "0"
+	}
+
+	return msgId, nil
+}
+
+// StatArticleMsgId checks that an article exists in a group the session can
+// read. The article number is 0 unless the article is in the selected group.
+func (s *Connection) StatArticleMsgId(group *nntp.Group, id string) (int64, error) {
+	groups, found, err := s.Server.Articles.MsgIdGroups(id)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return -1, nntpserver.ErrFault
+	} else if !found {
+		return -1, nntpserver.ErrInvalidMessageID
+	} else if err := s.checkReadAny(groups); err != nil {
+		return -1, err
+	}
+
+	if group == nil || !contains(groups, group.Name) {
+		return 0, nil
+	}
+
+	num, err := s.Server.Articles.ArticleNum(group.Name, id)
+	if err != nil && err != articles.ErrNoGroup {
+		log.Printf("ERROR: %v", err)
+		return -1, nntpserver.ErrFault
+	}
+	return num, nil
+}
+
+func (s *Connection) GetHeadNum(group *nntp.Group, num int64) (io.ReadCloser, string, error) {
+	art, msgId, err := s.GetArticleNum(group, num)
+	if err != nil {
+		return nil, msgId, err
+	}
+	return message.HeadReader(art), msgId, nil
+}
+
+func (s *Connection) GetHeadMsgId(group *nntp.Group, id string) (io.ReadCloser, int64, error) {
+	art, num, err := s.GetArticleMsgId(group, id)
+	if err != nil {
+		return nil, num, err
+	}
+	return message.HeadReader(art), num, nil
+}
+
+func (s *Connection) GetBodyNum(group *nntp.Group, num int64) (io.ReadCloser, string, error) {
+	art, msgId, err := s.GetArticleNum(group, num)
+	if err != nil {
+		return nil, msgId, err
+	}
+
+	body, err := message.BodyReader(art)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, "0", nntpserver.ErrFault
+	}
+	return body, msgId, nil
+}
+
+func (s *Connection) GetBodyMsgId(group *nntp.Group, id string) (io.ReadCloser, int64, error) {
+	art, num, err := s.GetArticleMsgId(group, id)
+	if err != nil {
+		return nil, num, err
+	}
+
+	body, err := message.BodyReader(art)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, -1, nntpserver.ErrFault
+	}
+	return body, num, nil
+}
+
+// NextArticle returns the first existing article after num
+func (s *Connection) NextArticle(group *nntp.Group, num int64) (int64, string, error) {
+	return s.moveArticle(group, num, s.Server.Articles.ArticleAfter, ErrNoNextArticle)
+}
+
+// LastArticle returns the last existing article before num
+func (s *Connection) LastArticle(group *nntp.Group, num int64) (int64, string, error) {
+	return s.moveArticle(group, num, s.Server.Articles.ArticleBefore, ErrNoPrevArticle)
+}
+
+func (s *Connection) moveArticle(group *nntp.Group, num int64, move func(string, int64) (int64, string, error), errNone error) (int64, string, error) {
+	if group == nil {
+		return 0, "0", nntpserver.ErrNoGroupSelected
+	} else if num <= 0 {
+		return 0, "0", nntpserver.ErrNoCurrentArticle
+	} else if err := s.checkRead(group.Name); err != nil {
+		return 0, "0", err
+	}
+
+	num, msgId, err := move(group.Name, num)
+	if err == articles.ErrNoGroup {
+		return 0, "0", nntpserver.ErrNoSuchGroup
+	} else if err != nil {
+		log.Printf("ERROR: %v", err)
+		return 0, "0", nntpserver.ErrFault
+	} else if num == 0 {
+		return 0, "0", errNone
+	}
+
+	if msgId == "" {
+		msgId = "0"
+	}
+
+	return num, msgId, nil
+}
+
+// ListArticles returns the numbers of the existing articles in from..to for
+// LISTGROUP. A negative to means no upper bound.
+func (s *Connection) ListArticles(group *nntp.Group, from, to int64) ([]int64, error) {
+	if group == nil {
+		return nil, nntpserver.ErrNoGroupSelected
+	} else if err := s.checkRead(group.Name); err != nil {
+		return nil, err
+	}
+
+	nums, err := s.Server.Articles.ListNums(group.Name, from, to)
+	if err == articles.ErrNoGroup {
+		return nil, nntpserver.ErrNoSuchGroup
+	} else if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, nntpserver.ErrFault
+	}
+	return nums, nil
+}
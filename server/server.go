@@ -6,9 +6,9 @@ import (
 	"log"
 	"net"
 	"sync"
+	"time"
 
-	"github.com/dustin/go-nntp/server"
-
+	"github.com/mildred/newsweb/accounts"
 	"github.com/mildred/newsweb/articles"
 	"github.com/mildred/newsweb/mailer"
 	"github.com/mildred/newsweb/validations"
@@ -17,12 +17,30 @@ import (
 type Server struct {
 	Articles    *articles.Articles
 	Validations *validations.Validations
+	Accounts    *accounts.Accounts
 	Mailer      *mailer.Mailer
 	ListenAddr  string
+	// Domain used to generate Message-IDs
+	Domain string
+
+	// How long a posted article waits for its sender to be validated
+	PendingExpire time.Duration
+	// How long an article to a moderated group waits for moderators
+	ModerationExpire time.Duration
+	// Reject posts to groups that were not created by an administrator
+	RequireExistingGroups bool
+	// Maximum number of groups an article can be posted to, 0 for no limit
+	MaxCrossPost int
+	// Armored keyring of the administrators trusted to send newgroup and
+	// rmgroup control articles
+	ControlKeyring string
+	// Group where group control articles are filed, empty to disable
+	AuditGroup string
 }
 
-func (s *Server) Start(ctx context.Context) error {
-	var wg = new(sync.WaitGroup)
+// Start the mailer and the NNTP server. It returns when the context is done
+// and every goroutine registered in wg has finished.
+func (s *Server) Start(ctx context.Context, wg *sync.WaitGroup) error {
 	err := s.Mailer.Start(ctx, wg)
 	if err != nil {
 		return err
@@ -65,8 +83,7 @@ func (s *Server) Start(ctx context.Context) error {
 		}
 
 		// TODO: pass context
-		var cnx = &Connection{s}
-		srv := nntpserver.NewServer(cnx)
+		var cnx = &Connection{Server: s}
 		wg.Add(1)
 		go func() {
 			<-ctx.Done()
@@ -74,7 +91,7 @@ func (s *Server) Start(ctx context.Context) error {
 		}()
 		go func() {
 			defer wg.Done()
-			srv.Process(c)
+			serve(cnx, c)
 		}()
 	}
 
@@ -0,0 +1,679 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-nntp"
+	"github.com/dustin/go-nntp/server"
+)
+
+// session serves the RFC 3977 reading commands of one NNTP connection from a
+// ReaderBackend. The backend is replaced when AUTHINFO succeeds.
+type session struct {
+	backend ReaderBackend
+	conn    *textproto.Conn
+	// Selected group and current article number, 0 if none
+	group *nntp.Group
+	num   int64
+	// User name given by AUTHINFO USER, waiting for AUTHINFO PASS
+	user string
+	// Command line being processed
+	line string
+}
+
+type sessionHandler func(s *session, args []string) error
+
+var sessionHandlers map[string]sessionHandler
+
+func init() {
+	sessionHandlers = map[string]sessionHandler{
+		"article":      (*session).handleArticle,
+		"authinfo":     (*session).handleAuthInfo,
+		"body":         (*session).handleArticle,
+		"capabilities": (*session).handleCapabilities,
+		"date":         (*session).handleDate,
+		"group":        (*session).handleGroup,
+		"hdr":          (*session).handleHdr,
+		"head":         (*session).handleArticle,
+		"help":         (*session).handleHelp,
+		"last":         (*session).handleLast,
+		"list":         (*session).handleList,
+		"listgroup":    (*session).handleListGroup,
+		"mode":         (*session).handleMode,
+		"newgroups":    (*session).handleNewGroups,
+		"newnews":      (*session).handleNewNews,
+		"next":         (*session).handleNext,
+		"over":         (*session).handleOver,
+		"post":         (*session).handlePost,
+		"stat":         (*session).handleArticle,
+		"xhdr":         (*session).handleHdr,
+		"xover":        (*session).handleOver,
+	}
+}
+
+// serve processes commands until the client quits or the connection closes
+func serve(backend ReaderBackend, c net.Conn) {
+	s := &session{backend: backend, conn: textproto.NewConn(c)}
+	defer s.conn.Close()
+
+	greeting := "200 newsweb ready, posting allowed"
+	if !backend.AllowPost() {
+		greeting = "201 newsweb ready, no posting"
+	}
+	if s.conn.PrintfLine(greeting) != nil {
+		return
+	}
+
+	for {
+		line, err := s.conn.ReadLine()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("ERROR: %v", err)
+			}
+			return
+		}
+
+		s.line = line
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			err = nntpserver.ErrUnknownCommand
+		} else if cmd := strings.ToLower(args[0]); cmd == "quit" {
+			s.conn.PrintfLine("205 bye")
+			return
+		} else if handler, ok := sessionHandlers[cmd]; ok {
+			err = handler(s, args)
+		} else {
+			err = nntpserver.ErrUnknownCommand
+		}
+
+		if nntpErr, ok := err.(*nntpserver.NNTPError); ok {
+			err = s.conn.PrintfLine("%d %s", nntpErr.Code, nntpErr.Msg)
+		} else if err != nil {
+			log.Printf("ERROR: %v", err)
+			err = s.conn.PrintfLine("%d %s", nntpserver.ErrFault.Code, nntpserver.ErrFault.Msg)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeLines sends a multi-line response
+func (s *session) writeLines(status string, lines []string) error {
+	err := s.conn.PrintfLine("%s", status)
+	if err != nil {
+		return err
+	}
+
+	w := s.conn.DotWriter()
+	for _, line := range lines {
+		_, err = io.WriteString(w, line+"\r\n")
+		if err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// writeBody sends a multi-line response with the content of r
+func (s *session) writeBody(status string, r io.Reader) error {
+	err := s.conn.PrintfLine("%s", status)
+	if err != nil {
+		return err
+	}
+
+	w := s.conn.DotWriter()
+	_, err = io.Copy(w, r)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (s *session) handleCapabilities(args []string) error {
+	var caps = []string{
+		"VERSION 2",
+		"READER",
+		"HDR",
+		"NEWNEWS",
+		"LIST ACTIVE NEWSGROUPS OVERVIEW.FMT HEADERS",
+		"OVER",
+		"AUTHINFO USER",
+	}
+	if s.backend.AllowPost() {
+		caps = append(caps, "POST")
+	}
+	return s.writeLines("101 Capability list:", caps)
+}
+
+func (s *session) handleHelp(args []string) error {
+	return s.writeLines("100 Legal commands", []string{
+		"  ARTICLE|HEAD|BODY|STAT [message-id|number]",
+		"  AUTHINFO USER name|PASS password",
+		"  CAPABILITIES",
+		"  DATE",
+		"  GROUP newsgroup",
+		"  HDR field [range]",
+		"  LAST",
+		"  LIST [ACTIVE|NEWSGROUPS [wildmat]|OVERVIEW.FMT|HEADERS]",
+		"  LISTGROUP [newsgroup [range]]",
+		"  MODE READER",
+		"  NEWGROUPS [yy]yymmdd hhmmss [GMT]",
+		"  NEWNEWS wildmat [yy]yymmdd hhmmss [GMT]",
+		"  NEXT",
+		"  OVER [range]",
+		"  POST",
+		"  QUIT",
+	})
+}
+
+func (s *session) handleDate(args []string) error {
+	return s.conn.PrintfLine("111 %s", time.Now().UTC().Format("20060102150405"))
+}
+
+func (s *session) handleMode(args []string) error {
+	if len(args) != 2 || strings.ToLower(args[1]) != "reader" {
+		return nntpserver.ErrSyntax
+	} else if s.backend.AllowPost() {
+		return s.conn.PrintfLine("200 Posting allowed")
+	}
+	return s.conn.PrintfLine("201 Posting prohibited")
+}
+
+func (s *session) selectGroup(name string) error {
+	group, err := s.backend.GetGroup(name)
+	if err != nil {
+		return err
+	}
+
+	s.group = group
+	s.num = 0
+	if group.Count > 0 {
+		s.num = group.Low
+	}
+	return nil
+}
+
+func (s *session) groupStatus() string {
+	return fmt.Sprintf("211 %d %d %d %s", s.group.Count, s.group.Low, s.group.High, s.group.Name)
+}
+
+func (s *session) handleGroup(args []string) error {
+	if len(args) != 2 {
+		return nntpserver.ErrSyntax
+	}
+
+	err := s.selectGroup(args[1])
+	if err != nil {
+		return err
+	}
+	return s.conn.PrintfLine("%s", s.groupStatus())
+}
+
+func (s *session) handleListGroup(args []string) error {
+	if len(args) > 3 {
+		return nntpserver.ErrSyntax
+	} else if len(args) >= 2 {
+		err := s.selectGroup(args[1])
+		if err != nil {
+			return err
+		}
+	} else if s.group == nil {
+		return nntpserver.ErrNoGroupSelected
+	}
+
+	var from, to int64 = s.group.Low, -1
+	if len(args) == 3 {
+		var err error
+		from, to, err = parseRange(args[2])
+		if err != nil {
+			return err
+		}
+	}
+
+	nums, err := s.backend.ListArticles(s.group, from, to)
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	for _, num := range nums {
+		lines = append(lines, strconv.FormatInt(num, 10))
+	}
+	return s.writeLines(s.groupStatus()+" list follows", lines)
+}
+
+func (s *session) handleNext(args []string) error {
+	num, msgId, err := s.backend.NextArticle(s.group, s.num)
+	if err != nil {
+		return err
+	}
+	s.num = num
+	return s.conn.PrintfLine("223 %d %s", num, msgId)
+}
+
+func (s *session) handleLast(args []string) error {
+	num, msgId, err := s.backend.LastArticle(s.group, s.num)
+	if err != nil {
+		return err
+	}
+	s.num = num
+	return s.conn.PrintfLine("223 %d %s", num, msgId)
+}
+
+// handleArticle serves ARTICLE, HEAD, BODY and STAT by Message-ID, by number
+// in the selected group, or for the current article
+func (s *session) handleArticle(args []string) error {
+	if len(args) > 2 {
+		return nntpserver.ErrSyntax
+	}
+	cmd := strings.ToLower(args[0])
+
+	if len(args) == 2 && strings.HasPrefix(args[1], "<") {
+		return s.articleMsgId(cmd, args[1])
+	}
+
+	var num = s.num
+	if len(args) == 2 {
+		var err error
+		num, err = strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return nntpserver.ErrSyntax
+		}
+	} else if s.group != nil && num <= 0 {
+		return nntpserver.ErrNoCurrentArticle
+	}
+
+	var r io.ReadCloser
+	var msgId string
+	var err error
+	switch cmd {
+	case "article":
+		r, msgId, err = s.backend.GetArticleNum(s.group, num)
+	case "head":
+		r, msgId, err = s.backend.GetHeadNum(s.group, num)
+	case "body":
+		r, msgId, err = s.backend.GetBodyNum(s.group, num)
+	default:
+		msgId, err = s.backend.StatArticleNum(s.group, num)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.num = num
+	return s.writeArticle(cmd, num, msgId, r)
+}
+
+func (s *session) articleMsgId(cmd, msgId string) error {
+	var r io.ReadCloser
+	var num int64
+	var err error
+	switch cmd {
+	case "article":
+		r, num, err = s.backend.GetArticleMsgId(s.group, msgId)
+	case "head":
+		r, num, err = s.backend.GetHeadMsgId(s.group, msgId)
+	case "body":
+		r, num, err = s.backend.GetBodyMsgId(s.group, msgId)
+	default:
+		num, err = s.backend.StatArticleMsgId(s.group, msgId)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The current article does not change when the article is selected by
+	// Message-ID
+	return s.writeArticle(cmd, num, msgId, r)
+}
+
+func (s *session) writeArticle(cmd string, num int64, msgId string, r io.ReadCloser) error {
+	if r == nil {
+		return s.conn.PrintfLine("223 %d %s", num, msgId)
+	}
+	defer r.Close()
+
+	switch cmd {
+	case "article":
+		return s.writeBody(fmt.Sprintf("220 %d %s", num, msgId), r)
+	case "head":
+		return s.writeBody(fmt.Sprintf("221 %d %s", num, msgId), r)
+	default:
+		return s.writeBody(fmt.Sprintf("222 %d %s", num, msgId), r)
+	}
+}
+
+func (s *session) handleList(args []string) error {
+	variant := "active"
+	if len(args) >= 2 {
+		variant = strings.ToLower(args[1])
+	}
+	var wildmat string
+	if len(args) == 3 {
+		wildmat = args[2]
+	} else if len(args) > 3 {
+		return nntpserver.ErrSyntax
+	}
+
+	switch variant {
+	case "active":
+		return s.streamList(func(line func(string) error) error {
+			return s.backend.ListActive(wildmat, func(grp *nntp.Group) error {
+				return line(fmt.Sprintf("%s %d %d %c", grp.Name, grp.High, grp.Low, grp.Posting))
+			})
+		})
+	case "newsgroups":
+		return s.streamList(func(line func(string) error) error {
+			return s.backend.ListNewsgroups(wildmat, func(name, description string) error {
+				return line(fmt.Sprintf("%s\t%s", name, description))
+			})
+		})
+	case "overview.fmt":
+		return s.writeLines("215 Order of fields in overview database", s.backend.ListOverviewFmt())
+	case "headers":
+		// Every field can be retrieved by range, none by Message-ID
+		return s.writeLines("215 Field list follows", s.backend.ListHeaders())
+	default:
+		return nntpserver.ErrSyntax
+	}
+}
+
+// streamList sends the lines produced by each as a LIST response, started
+// with the first line so that errors before it can still be reported
+func (s *session) streamList(each func(line func(string) error) error) error {
+	var w io.WriteCloser
+	err := each(func(line string) error {
+		if w == nil {
+			err := s.conn.PrintfLine("215 List follows")
+			if err != nil {
+				return err
+			}
+			w = s.conn.DotWriter()
+		}
+		_, err := io.WriteString(w, line+"\r\n")
+		return err
+	})
+	if w == nil && err == nil {
+		return s.writeLines("215 List follows", nil)
+	} else if w == nil {
+		return err
+	}
+
+	closeErr := w.Close()
+	if err != nil {
+		// The response is already started, the error cannot be reported
+		return fmt.Errorf("LIST interrupted: %v", err)
+	}
+	return closeErr
+}
+
+func (s *session) handleNewGroups(args []string) error {
+	since, err := dateTimeArgs(args[1:])
+	if err != nil {
+		return err
+	}
+
+	groups, err := s.backend.NewGroups(since)
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	for _, grp := range groups {
+		lines = append(lines, fmt.Sprintf("%s %d %d %c", grp.Name, grp.High, grp.Low, grp.Posting))
+	}
+	return s.writeLines("231 List of new newsgroups follows", lines)
+}
+
+func (s *session) handleNewNews(args []string) error {
+	if len(args) < 2 {
+		return nntpserver.ErrSyntax
+	}
+	since, err := dateTimeArgs(args[2:])
+	if err != nil {
+		return err
+	}
+
+	msgIds, err := s.backend.NewNews(args[1], since)
+	if err != nil {
+		return err
+	}
+	return s.writeLines("230 List of new articles follows", msgIds)
+}
+
+// overview returns the overview of the articles in the range given as
+// argument, or of the current article
+func (s *session) overview(args []string) ([]nntpserver.NumberedArticle, error) {
+	if s.group == nil {
+		return nil, nntpserver.ErrNoGroupSelected
+	}
+
+	var from, to = s.num, s.num
+	if len(args) == 0 && s.num <= 0 {
+		return nil, nntpserver.ErrNoCurrentArticle
+	} else if len(args) == 1 && strings.HasPrefix(args[0], "<") {
+		// Message-ID forms are not advertised
+		return nil, nntpserver.ErrSyntax
+	} else if len(args) == 1 {
+		var err error
+		from, to, err = parseRange(args[0])
+		if err != nil {
+			return nil, err
+		} else if to < 0 {
+			// Articles may have been posted since the group was selected
+			group, err := s.backend.GetGroup(s.group.Name)
+			if err != nil {
+				return nil, err
+			}
+			to = group.High
+		}
+	} else if len(args) > 1 {
+		return nil, nntpserver.ErrSyntax
+	}
+
+	arts, err := s.backend.GetArticles(s.group, from, to)
+	if err != nil {
+		return nil, err
+	} else if len(arts) == 0 {
+		return nil, nntpserver.ErrInvalidArticleNumber
+	}
+	return arts, nil
+}
+
+func (s *session) handleOver(args []string) error {
+	arts, err := s.overview(args[1:])
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	for _, a := range arts {
+		lines = append(lines, fmt.Sprintf("%d\t%s\t%s\t%s\t%s\t%s\t%d\t%d", a.Num,
+			overviewField(a.Article.Header.Get("Subject")),
+			overviewField(a.Article.Header.Get("From")),
+			overviewField(a.Article.Header.Get("Date")),
+			overviewField(a.Article.Header.Get("Message-Id")),
+			overviewField(a.Article.Header.Get("References")),
+			a.Article.Bytes, a.Article.Lines))
+	}
+	return s.writeLines("224 Overview information follows", lines)
+}
+
+func (s *session) handleHdr(args []string) error {
+	if len(args) < 2 {
+		return nntpserver.ErrSyntax
+	}
+	field := strings.ToLower(args[1])
+
+	var known bool
+	for _, name := range s.backend.ListHeaders() {
+		known = known || strings.ToLower(name) == field
+	}
+	if !known {
+		return &nntpserver.NNTPError{Code: 503, Msg: "Header not in overview"}
+	}
+
+	arts, err := s.overview(args[2:])
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	for _, a := range arts {
+		var value string
+		switch field {
+		case ":bytes":
+			value = strconv.Itoa(a.Article.Bytes)
+		case ":lines":
+			value = strconv.Itoa(a.Article.Lines)
+		default:
+			value = overviewField(a.Article.Header.Get(field))
+		}
+		lines = append(lines, fmt.Sprintf("%d %s", a.Num, value))
+	}
+	return s.writeLines("225 Headers follow", lines)
+}
+
+func (s *session) handlePost(args []string) error {
+	if !s.backend.AllowPost() {
+		return nntpserver.ErrPostingNotPermitted
+	}
+
+	err := s.conn.PrintfLine("340 Send article to be posted")
+	if err != nil {
+		return err
+	}
+
+	r := s.conn.DotReader()
+	err = s.backend.Post(r)
+	// Consume the rest of the article if the backend stopped reading early
+	_, copyErr := io.Copy(ioutil.Discard, r)
+	if copyErr != nil {
+		return copyErr
+	} else if err != nil {
+		return err
+	}
+	return s.conn.PrintfLine("240 Article received OK")
+}
+
+// handleAuthInfo authenticates with AUTHINFO USER and PASS. As specified by
+// RFC 4643, the user name and the password are the rest of the line and can
+// contain spaces.
+func (s *session) handleAuthInfo(args []string) error {
+	if len(args) < 3 {
+		return nntpserver.ErrSyntax
+	}
+	value := lineArgument(s.line, 2)
+
+	switch strings.ToLower(args[1]) {
+	case "user":
+		s.user = value
+		return s.conn.PrintfLine("381 Password required")
+
+	case "pass":
+		if s.user == "" {
+			return &nntpserver.NNTPError{Code: 482, Msg: "Authentication commands issued out of sequence"}
+		}
+		backend, err := s.backend.Authenticate(s.user, value)
+		s.user = ""
+		if err == nntpserver.ErrAuthRejected {
+			// go-nntp answers 452, the RFC 2980 code, RFC 4643 uses 481
+			return &nntpserver.NNTPError{Code: 481, Msg: "Authentication failed"}
+		} else if err != nil {
+			return err
+		} else if reader, ok := backend.(ReaderBackend); ok {
+			s.backend = reader
+		}
+		return s.conn.PrintfLine("281 Authentication accepted")
+
+	default:
+		return nntpserver.ErrSyntax
+	}
+}
+
+// lineArgument returns the rest of a command line after n words
+func lineArgument(line string, n int) string {
+	for i := 0; i < n; i++ {
+		line = strings.TrimLeft(line, " \t")
+		if end := strings.IndexAny(line, " \t"); end >= 0 {
+			line = line[end:]
+		} else {
+			return ""
+		}
+	}
+	return strings.TrimLeft(line, " \t")
+}
+
+// parseRange parses an RFC 3977 range: a number, "n-" or "n-m". The upper
+// bound of "n-" is -1.
+func parseRange(spec string) (from, to int64, err error) {
+	parts := strings.SplitN(spec, "-", 2)
+	from, err = strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, nntpserver.ErrSyntax
+	}
+
+	switch {
+	case len(parts) == 1:
+		to = from
+	case parts[1] == "":
+		to = -1
+	default:
+		to, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return 0, 0, nntpserver.ErrSyntax
+		}
+	}
+	return from, to, nil
+}
+
+// dateTimeArgs parses the date, the time and the optional GMT token of
+// NEWGROUPS and NEWNEWS. Without GMT the time is the server local time.
+func dateTimeArgs(args []string) (time.Time, error) {
+	var loc = time.Local
+	if len(args) == 3 && strings.ToUpper(args[2]) == "GMT" {
+		loc = time.UTC
+	} else if len(args) != 2 {
+		return time.Time{}, nntpserver.ErrSyntax
+	}
+	return parseDateTime(args[0], args[1], loc)
+}
+
+// parseDateTime parses a date and time in loc. Two-digit years are in the
+// current century unless that is in the future.
+func parseDateTime(date, clock string, loc *time.Location) (time.Time, error) {
+	if len(date) == 6 {
+		year := time.Now().In(loc).Year()
+		century := year / 100 * 100
+		yy, err := strconv.Atoi(date[:2])
+		if err != nil {
+			return time.Time{}, nntpserver.ErrSyntax
+		} else if century+yy > year {
+			century -= 100
+		}
+		date = strconv.Itoa(century/100) + date
+	}
+
+	t, err := time.ParseInLocation("20060102 150405", date+" "+clock, loc)
+	if err != nil {
+		return time.Time{}, nntpserver.ErrSyntax
+	}
+	return t, nil
+}
+
+// overviewField removes the characters that cannot appear in an overview
+// field
+func overviewField(value string) string {
+	return strings.NewReplacer("\t", " ", "\r", "", "\n", "").Replace(value)
+}
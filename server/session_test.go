@@ -0,0 +1,315 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dustin/go-nntp/server"
+
+	"github.com/mildred/newsweb/accounts"
+	"github.com/mildred/newsweb/articles"
+	"github.com/mildred/newsweb/validations"
+)
+
+// startTestServer serves an article store with two articles in a.b and one
+// cross-posted to c.d on a local TCP port. author@example.org can log in with
+// the password "open sesame".
+func startTestServer(t *testing.T) (addr string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "newsweb-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ar := &articles.Articles{StorageDir: dir, ServerName: "news.example.org"}
+	val := &validations.Validations{StorageDir: dir}
+	acc := &accounts.Accounts{StorageDir: dir}
+	for _, db := range []interface{ Open() error }{ar, val, acc} {
+		err = db.Open()
+		if err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+
+	err = acc.RequestPassword("author@example.org", "open sesame", "token")
+	if err == nil {
+		_, err = acc.ConfirmPassword("author@example.org", "token")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, groups := range [][]string{{"a.b"}, {"a.b", "c.d"}} {
+		msgId := fmt.Sprintf("<%d@test>", i+1)
+		data := fmt.Sprintf("From: author@example.org\nNewsgroups: %s\nSubject: Article %d\nMessage-ID: %s\n\nBody %d\n.dotted\n",
+			strings.Join(groups, ","), i+1, msgId, i+1)
+		err = ar.Post(groups, msgId, []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &Server{Articles: ar, Validations: val, Accounts: acc, Domain: "news.example.org"}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(&Connection{Server: srv}, c)
+		}
+	}()
+
+	return l.Addr().String(), func() {
+		l.Close()
+		ar.Close()
+		val.Close()
+		acc.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSessionCommands(t *testing.T) {
+	addr, cleanup := startTestServer(t)
+	defer cleanup()
+
+	c, err := textproto.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, _, err = c.ReadCodeLine(200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Commands run in order on the same connection, lines is the expected
+	// multi-line response, nil for single-line responses
+	var tests = []struct {
+		cmd   string
+		code  int
+		line  string
+		lines []string
+	}{
+		{"MODE READER", 200, "", nil},
+		{"STAT <1@test>", 223, "0 <1@test>", nil},
+		{"HEAD <3@test>", 430, "", nil},
+		{"NEXT", 412, "", nil},
+		{"LIST ACTIVE a.*", 215, "", []string{"a.b 2 1 y"}},
+		{"LIST NEWSGROUPS c.*", 215, "", []string{"c.d\t"}},
+		{"LIST OVERVIEW.FMT", 215, "", articles.OverviewFields},
+		{"GROUP a.b", 211, "2 1 2 a.b", nil},
+		{"STAT", 223, "1 <1@test>", nil},
+		{"LAST", 422, "", nil},
+		{"NEXT", 223, "2 <2@test>", nil},
+		{"NEXT", 421, "", nil},
+		{"BODY", 222, "2 <2@test>", []string{"Body 2", ".dotted"}},
+		{"BODY 1", 222, "1 <1@test>", []string{"Body 1", ".dotted"}},
+		{"STAT <2@test>", 223, "2 <2@test>", nil},
+		{"LISTGROUP c.d", 211, "1 1 1 c.d list follows", []string{"1"}},
+		{"LISTGROUP a.b 2-", 211, "2 1 2 a.b list follows", []string{"2"}},
+		{"HDR Subject 1-2", 225, "", []string{"1 Article 1", "2 Article 2"}},
+		{"NEWGROUPS 19700101 000000 GMT", 231, "", []string{"a.b 2 1 y", "c.d 1 1 y"}},
+		{"NEWNEWS c.* 19700101 000000", 230, "", []string{"<2@test>"}},
+		{"NEWNEWS * " + time.Now().Add(time.Hour).UTC().Format("20060102 150405"), 230, "", []string{}},
+		{"FOO", 500, "", nil},
+	}
+
+	for _, test := range tests {
+		id, err := c.Cmd("%s", test.cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.StartResponse(id)
+		_, line, err := c.ReadCodeLine(test.code)
+		if err != nil {
+			c.EndResponse(id)
+			t.Fatalf("%s: %v", test.cmd, err)
+		}
+		if test.line != "" && line != test.line {
+			t.Errorf("%s: expected %q, got %q", test.cmd, test.line, line)
+		}
+
+		if test.lines != nil {
+			lines, err := c.ReadDotLines()
+			if err != nil {
+				c.EndResponse(id)
+				t.Fatalf("%s: %v", test.cmd, err)
+			}
+			if fmt.Sprint(lines) != fmt.Sprint(test.lines) {
+				t.Errorf("%s: expected %q, got %q", test.cmd, test.lines, lines)
+			}
+		}
+		c.EndResponse(id)
+	}
+}
+
+func TestSessionPost(t *testing.T) {
+	addr, cleanup := startTestServer(t)
+	defer cleanup()
+
+	c, err := textproto.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var steps = []struct {
+		cmd  string
+		code int
+		line string
+	}{
+		{"", 200, ""},
+		{"AUTHINFO USER author@example.org", 381, ""},
+		{"AUTHINFO PASS open sesame", 281, ""},
+		{"GROUP a.b", 211, "2 1 2 a.b"},
+		{"POST", 340, ""},
+		{"From: author@example.org\r\nNewsgroups: a.b\r\nSubject: Posted\r\nMessage-ID: <posted@test>\r\n\r\n.. Body\r\n.", 240, ""},
+		{"STAT <posted@test>", 223, ""},
+		{"POST", 340, ""},
+		{"From: author@example.org\r\nNewsgroups: a.b\r\nSubject: Again\r\nMessage-ID: <posted@test>\r\n\r\nBody\r\n.", 441, ""},
+	}
+
+	for _, step := range steps {
+		if step.cmd != "" {
+			err = c.PrintfLine("%s", step.cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, line, err := c.ReadCodeLine(step.code)
+		if err != nil {
+			t.Fatalf("%q: %v", step.cmd, err)
+		} else if step.line != "" && line != step.line {
+			t.Errorf("%q: expected %q, got %q", step.cmd, step.line, line)
+		}
+	}
+
+	// The range end is the current high mark, not the one of GROUP
+	err = c.PrintfLine("HDR Subject 2-")
+	if err == nil {
+		_, _, err = c.ReadCodeLine(225)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, err := c.ReadDotLines()
+	if err != nil {
+		t.Fatal(err)
+	} else if fmt.Sprint(lines) != fmt.Sprint([]string{"2 Article 2", "3 Posted"}) {
+		t.Errorf("expected the posted article in the range, got %q", lines)
+	}
+
+	subject, body, err := readTestArticle(c, "<posted@test>")
+	if err != nil {
+		t.Fatal(err)
+	} else if subject != "Posted" {
+		t.Errorf("expected subject Posted, got %q", subject)
+	} else if fmt.Sprint(body) != fmt.Sprint([]string{". Body"}) {
+		t.Errorf("expected the dot-unstuffed body, got %q", body)
+	}
+}
+
+// readTestArticle reads the subject and the body of an article
+func readTestArticle(c *textproto.Conn, msgId string) (subject string, body []string, err error) {
+	err = c.PrintfLine("ARTICLE %s", msgId)
+	if err != nil {
+		return
+	}
+	_, _, err = c.ReadCodeLine(220)
+	if err != nil {
+		return
+	}
+	header, err := c.ReadMIMEHeader()
+	if err != nil {
+		return
+	}
+	body, err = c.ReadDotLines()
+	return header.Get("Subject"), body, err
+}
+
+func TestSessionAuthInfo(t *testing.T) {
+	addr, cleanup := startTestServer(t)
+	defer cleanup()
+
+	var tests = []struct {
+		name  string
+		cmds  []string
+		codes []int
+	}{
+		{"password with a space", []string{"AUTHINFO USER author@example.org", "AUTHINFO PASS open sesame"}, []int{381, 281}},
+		{"first word of the password", []string{"AUTHINFO USER author@example.org", "AUTHINFO PASS open"}, []int{381, 481}},
+		{"pass before user", []string{"AUTHINFO PASS open sesame"}, []int{482}},
+		{"missing argument", []string{"AUTHINFO USER"}, []int{501}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := textproto.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			_, _, err = c.ReadCodeLine(200)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, cmd := range test.cmds {
+				err = c.PrintfLine("%s", cmd)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, _, err = c.ReadCodeLine(test.codes[i])
+				if err != nil {
+					t.Errorf("%s: %v", cmd, err)
+				}
+			}
+		})
+	}
+}
+
+func TestParseDateTime(t *testing.T) {
+	var zone = time.FixedZone("UTC+2", 2*3600)
+	var tests = []struct {
+		args     []string
+		loc      *time.Location
+		expected time.Time
+	}{
+		{[]string{"20200102", "030405", "GMT"}, zone, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{[]string{"20200102", "030405", "gmt"}, zone, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{[]string{"20200102", "030405"}, zone, time.Date(2020, 1, 2, 1, 4, 5, 0, time.UTC)},
+		{[]string{"200102", "030405", "GMT"}, zone, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{[]string{"20200102", "030405", "UTC"}, zone, time.Time{}},
+		{[]string{"20200102"}, zone, time.Time{}},
+	}
+
+	var local = time.Local
+	defer func() { time.Local = local }()
+
+	for _, test := range tests {
+		time.Local = test.loc
+		actual, err := dateTimeArgs(test.args)
+		if test.expected.IsZero() {
+			if err != nntpserver.ErrSyntax {
+				t.Errorf("%v: expected a syntax error, got %v", test.args, err)
+			}
+		} else if err != nil {
+			t.Errorf("%v: %v", test.args, err)
+		} else if !actual.Equal(test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.args, test.expected, actual)
+		}
+	}
+}
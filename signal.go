@@ -9,7 +9,7 @@ import (
 )
 
 func mainContext() context.Context {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 	ctx, stop := context.WithCancel(context.Background())
 	go func() {
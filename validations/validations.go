@@ -3,40 +3,78 @@ package validations
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
 	"path"
 	"time"
 
 	"github.com/coreos/bbolt"
+	"golang.org/x/crypto/openpgp"
+
+	"github.com/mildred/newsweb/message"
 )
 
 const DbName = "validations.db"
+
+// OpenTimeout is how long Open waits for another process to release the
+// database
+var OpenTimeout = 5 * time.Second
+
 const TokenSize = 32
+const DefaultTokenTTL = 7 * 24 * time.Hour
+const DefaultTrustWindow = 30 * 24 * time.Hour
 
 const (
-	EmailTokenPrefix  = "email-token."  // email to token
-	TokenEmailPrefix  = "token-email."  // token to email
-	TokenExpirePrefix = "token-expire." // token to expiry date
-	TokenSep          = " "
+	EmailTokenPrefix     = "email-token."     // email to token
+	EmailValidatedPrefix = "email-validated." // email to last validation date
+	EmailTrustedPrefix   = "email-trusted."   // email to date until which it is trusted
+	EmailTrustKeyPrefix  = "email-trust-key." // email to hash of the key proving trust
+	EmailKeyPrefix       = "email-key."       // email to PGP key fingerprint
+	KeyPrefix            = "key."             // PGP key fingerprint to public key
+	TokenEmailPrefix     = "token-email."     // token to email
+	TokenExpirePrefix    = "token-expire."    // token to expiry date
+	TokenSep             = " "
+)
+
+var (
+	ErrUnknownToken  = errors.New("Unknown validation token")
+	ErrExpiredToken  = errors.New("Expired validation token")
+	ErrMismatchToken = errors.New("Validation token does not match e-mail address")
+	ErrNoKey         = errors.New("Validation is not signed with a PGP key")
+	ErrKeyMismatch   = errors.New("Validation signed with a different key than the one bound to the e-mail address")
+	ErrDatabaseInUse = errors.New("Database in use, stop the server first")
 )
 
 type Validations struct {
 	StorageDir string
-	db         *bolt.DB
+	TokenTTL   time.Duration
+	// How long an address is trusted after a validation, no validation is
+	// requested during this time for posts carrying the validation token as
+	// trust key.
+	TrustWindow time.Duration
+	db          *bolt.DB
 }
 
 func (v *Validations) Open() error {
 	var err error
 	v.Close()
-	v.db, err = bolt.Open(path.Join(v.StorageDir, DbName), 0644, nil)
+	v.db, err = bolt.Open(path.Join(v.StorageDir, DbName), 0644, &bolt.Options{Timeout: OpenTimeout})
+	if err == bolt.ErrTimeout {
+		return ErrDatabaseInUse
+	}
 	return err
 }
 
 func (v *Validations) Close() error {
 	if v.db != nil {
-		return v.db.Close()
+		err := v.db.Close()
 		v.db = nil
+		return err
 	}
 	return nil
 }
@@ -48,35 +86,219 @@ func (v *Validations) GenValidationToken(email string) (token string, err error)
 		panicIfError(err)
 
 		panicIfError(bucket.Put(encodeStrKey(TokenEmailPrefix, token), []byte(email)))
-		panicIfError(bucket.Put(encodeStrKey(TokenExpirePrefix, token), encodeTime(time.Now())))
+		panicIfError(bucket.Put(encodeStrKey(TokenExpirePrefix, token), encodeTime(time.Now().Add(v.tokenTTL()))))
 
-		tokens := bytes.Split(bucket.Get(encodeStrKey(EmailTokenPrefix, email)), []byte(TokenSep))
+		tokens := getEmailTokens(bucket, email)
 		tokens = append(tokens, []byte(token))
-		return bucket.Put(encodeStrKey(EmailTokenPrefix, email), bytes.Join(tokens, []byte(TokenSep)))
+		return putEmailTokens(bucket, email, tokens)
+	})
+	return
+}
+
+func (v *Validations) tokenTTL() time.Duration {
+	if v.TokenTTL <= 0 {
+		return DefaultTokenTTL
+	}
+	return v.TokenTTL
+}
+
+// ReceivedEmailToken checks a token received by e-mail against the stored
+// tokens. The reply must be signed with key. The first validation binds the
+// key to the e-mail address, later validations must use the same key. On
+// success the e-mail address is marked as validated and the token is consumed.
+func (v *Validations) ReceivedEmailToken(email, token string, key *openpgp.Entity) error {
+	if key == nil {
+		return ErrNoKey
+	}
+	fpr := message.Fingerprint(key)
+
+	// The expired token is removed in a committed transaction, the error is
+	// returned after it
+	var expired bool
+	err := v.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("validations"))
+		panicIfError(err)
+
+		tokenEmail := bucket.Get(encodeStrKey(TokenEmailPrefix, token))
+		if tokenEmail == nil {
+			return ErrUnknownToken
+		}
+		if string(tokenEmail) != email {
+			return ErrMismatchToken
+		}
+
+		expire, err := decodeTime(bucket.Get(encodeStrKey(TokenExpirePrefix, token)))
+		if err != nil || expire.Before(time.Now()) {
+			removeToken(bucket, email, token)
+			expired = true
+			return nil
+		}
+
+		boundFpr := bucket.Get(encodeStrKey(EmailKeyPrefix, email))
+		if boundFpr != nil && string(boundFpr) != fpr {
+			return ErrKeyMismatch
+		} else if boundFpr == nil {
+			var keyData bytes.Buffer
+			panicIfError(key.Serialize(&keyData))
+			panicIfError(bucket.Put(encodeStrKey(KeyPrefix, fpr), keyData.Bytes()))
+			panicIfError(bucket.Put(encodeStrKey(EmailKeyPrefix, email), []byte(fpr)))
+			log.Printf("INFO: Bound key %s to %s", fpr, email)
+		}
+
+		now := time.Now()
+		removeToken(bucket, email, token)
+		if v.TrustWindow > 0 {
+			panicIfError(bucket.Put(encodeStrKey(EmailTrustedPrefix, email), encodeTime(now.Add(v.TrustWindow))))
+			panicIfError(bucket.Put(encodeStrKey(EmailTrustKeyPrefix, email), hashTrustKey(token)))
+		}
+		return bucket.Put(encodeStrKey(EmailValidatedPrefix, email), encodeTime(now))
+	})
+	if err == nil && expired {
+		return ErrExpiredToken
+	}
+	return err
+}
+
+// IsTrusted tells if the e-mail address was validated recently enough that
+// posts can be published without a new validation. The address alone can be
+// forged, so the post must carry the token of the last validation as key.
+func (v *Validations) IsTrusted(email, key string) (trusted bool, err error) {
+	if key == "" {
+		return false, nil
+	}
+
+	err = v.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("validations"))
+		if bucket == nil {
+			return nil
+		}
+
+		data := bucket.Get(encodeStrKey(EmailTrustedPrefix, email))
+		hash := bucket.Get(encodeStrKey(EmailTrustKeyPrefix, email))
+		if data == nil || hash == nil || subtle.ConstantTimeCompare(hash, hashTrustKey(key)) != 1 {
+			return nil
+		}
+
+		until, err := decodeTime(data)
+		if err != nil {
+			return err
+		}
+		trusted = time.Now().Before(until)
+		return nil
+	})
+	return
+}
+
+// RevokeTrust ends the trust window of the e-mail address, the next post will
+// require a new validation.
+func (v *Validations) RevokeTrust(email string) error {
+	return v.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("validations"))
+		panicIfError(err)
+
+		panicIfError(bucket.Delete(encodeStrKey(EmailTrustKeyPrefix, email)))
+		return bucket.Delete(encodeStrKey(EmailTrustedPrefix, email))
+	})
+}
+
+func hashTrustKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return []byte(hex.EncodeToString(sum[:]))
+}
+
+// EmailKey returns the PGP key bound to the e-mail address, or nil if no key
+// was bound yet.
+func (v *Validations) EmailKey(email string) (key *openpgp.Entity, err error) {
+	err = v.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("validations"))
+		if bucket == nil {
+			return nil
+		}
+
+		fpr := bucket.Get(encodeStrKey(EmailKeyPrefix, email))
+		if fpr == nil {
+			return nil
+		}
+
+		keys, err := openpgp.ReadKeyRing(bytes.NewReader(bucket.Get(encodeStrKey(KeyPrefix, string(fpr)))))
+		if err != nil {
+			return err
+		} else if len(keys) == 0 {
+			return fmt.Errorf("key %s bound to %s is missing", fpr, email)
+		}
+		key = keys[0]
+		return nil
+	})
+	return
+}
+
+// ValidatedAt returns the last time the e-mail address was validated, or the
+// zero time if it never was.
+func (v *Validations) ValidatedAt(email string) (t time.Time, err error) {
+	err = v.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("validations"))
+		if bucket == nil {
+			return nil
+		}
+
+		data := bucket.Get(encodeStrKey(EmailValidatedPrefix, email))
+		if data != nil {
+			t, err = decodeTime(data)
+		}
+		return err
 	})
 	return
 }
 
-func (v *Validations) CleanTokensBefore(t time.Time) (err error) {
+func removeToken(bucket *bolt.Bucket, email, token string) {
+	panicIfError(bucket.Delete(encodeStrKey(TokenEmailPrefix, token)))
+	panicIfError(bucket.Delete(encodeStrKey(TokenExpirePrefix, token)))
+
+	var tokens [][]byte
+	for _, tok := range getEmailTokens(bucket, email) {
+		if string(tok) != token {
+			tokens = append(tokens, tok)
+		}
+	}
+	panicIfError(putEmailTokens(bucket, email, tokens))
+}
+
+func getEmailTokens(bucket *bolt.Bucket, email string) (tokens [][]byte) {
+	for _, tok := range bytes.Split(bucket.Get(encodeStrKey(EmailTokenPrefix, email)), []byte(TokenSep)) {
+		if len(tok) > 0 {
+			tokens = append(tokens, tok)
+		}
+	}
+	return
+}
+
+func putEmailTokens(bucket *bolt.Bucket, email string, tokens [][]byte) error {
+	if len(tokens) == 0 {
+		return bucket.Delete(encodeStrKey(EmailTokenPrefix, email))
+	}
+	return bucket.Put(encodeStrKey(EmailTokenPrefix, email), bytes.Join(tokens, []byte(TokenSep)))
+}
+
+// CleanTokensBefore removes every token that expired before t, along with its
+// e-mail indexes, in a single transaction. It returns the removed tokens.
+func (v *Validations) CleanTokensBefore(t time.Time) (tokens []string, err error) {
 	err = v.db.Update(func(tx *bolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists([]byte("validations"))
 		panicIfError(err)
 
+		prefix := []byte(TokenExpirePrefix)
 		cur := bucket.Cursor()
-		for k, v := cur.Seek([]byte(TokenExpirePrefix)); k != nil; k, v = cur.Next() {
-			//key := string(k)
-			//var token string
-			//if strings.HasPrefix(key, TokenExpirePrefix) {
-			//	token = key[len(TokenExpirePrefix):]
-			//}
-			time, err := decodeTime(v)
-			if err == nil {
-				continue
-			}
-			if time.Before(t) {
-				panicIfError(cur.Delete())
+		for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+			expire, err := decodeTime(v)
+			if err != nil || expire.Before(t) {
+				tokens = append(tokens, string(k[len(prefix):]))
 			}
 		}
+
+		for _, token := range tokens {
+			email := bucket.Get(encodeStrKey(TokenEmailPrefix, token))
+			removeToken(bucket, string(email), token)
+		}
 		return nil
 	})
 	return
@@ -89,9 +311,7 @@ func encodeStrKey(prefix, data string) []byte {
 func genHexToken(size int) string {
 	var data = make([]byte, size)
 	_, _ = rand.Read(data)
-	var enc bytes.Buffer
-	base64.NewEncoder(base64.RawURLEncoding, &enc).Write(data)
-	return string(enc.Bytes())
+	return base64.RawURLEncoding.EncodeToString(data)
 }
 
 func panicIfError(err error) {
@@ -0,0 +1,45 @@
+package validations
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+func TestExpiredTokenRemoved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "newsweb-validations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	v := &Validations{StorageDir: dir, TokenTTL: time.Nanosecond}
+	err = v.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+
+	key, err := openpgp.NewEntity("Author", "", "author@example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := v.GenValidationToken("author@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	err = v.ReceivedEmailToken("author@example.org", token, key)
+	if err != ErrExpiredToken {
+		t.Fatalf("expected ErrExpiredToken, got %v", err)
+	}
+	err = v.ReceivedEmailToken("author@example.org", token, key)
+	if err != ErrUnknownToken {
+		t.Errorf("expected the expired token to be removed, got %v", err)
+	}
+}
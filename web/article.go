@@ -0,0 +1,138 @@
+package web
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/textproto"
+	"strings"
+
+	gomessage "github.com/emersion/go-message"
+	// Decode the charsets beyond UTF-8 and US-ASCII
+	_ "github.com/emersion/go-message/charset"
+
+	"github.com/mildred/newsweb/articles"
+)
+
+// ArticleSummary contains the headers shown in article lists
+type ArticleSummary struct {
+	Num     int64
+	Subject string
+	From    string
+	Date    string
+	MsgId   string
+}
+
+// Article is an article decoded for display. Text parts are kept as plain
+// text, other parts are only listed.
+type Article struct {
+	ArticleSummary
+	Newsgroups string
+	References string
+	Parts      []*Part
+}
+
+type Part struct {
+	MediaType string
+	Filename  string
+	Text      string
+}
+
+var wordDecoder = new(mime.WordDecoder)
+
+func decodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+func readSummary(header textproto.MIMEHeader, art *ArticleSummary) {
+	art.Subject = decodeHeader(header.Get("Subject"))
+	art.From = decodeHeader(header.Get("From"))
+	art.Date = header.Get("Date")
+	art.MsgId = header.Get("Message-Id")
+}
+
+func summaryFromOverview(ov *articles.Overview) *ArticleSummary {
+	return &ArticleSummary{
+		Num:     ov.Num,
+		Subject: decodeHeader(ov.Subject),
+		From:    decodeHeader(ov.From),
+		Date:    ov.Date,
+		MsgId:   ov.MsgId,
+	}
+}
+
+// ReadSummary reads the article headers only
+func ReadSummary(r io.Reader) (*ArticleSummary, error) {
+	header, err := textproto.NewReader(bufio.NewReader(r)).ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var art = new(ArticleSummary)
+	readSummary(header, art)
+	return art, nil
+}
+
+// ReadArticle reads and decodes a full article. Parts in an unknown charset
+// are shown undecoded.
+func ReadArticle(r io.Reader) (*Article, error) {
+	entity, err := gomessage.Read(r)
+	if err != nil && !gomessage.IsUnknownCharset(err) {
+		return nil, err
+	}
+
+	var art = new(Article)
+	var header = textproto.MIMEHeader{}
+	for _, key := range []string{"Subject", "From", "Date", "Message-Id", "Newsgroups", "References"} {
+		header.Set(key, entity.Header.Get(key))
+	}
+	readSummary(header, &art.ArticleSummary)
+	art.Newsgroups = header.Get("Newsgroups")
+	art.References = header.Get("References")
+
+	err = art.readParts(entity)
+	return art, err
+}
+
+func (art *Article) readParts(e *gomessage.Entity) error {
+	if mr := e.MultipartReader(); mr != nil {
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			} else if err != nil && !gomessage.IsUnknownCharset(err) {
+				return err
+			}
+			err = art.readParts(part)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	mediaType, _, _ := e.Header.ContentType()
+	if mediaType == "" {
+		mediaType = "text/plain"
+	}
+
+	var part = &Part{MediaType: mediaType}
+	if _, params, err := mime.ParseMediaType(e.Header.Get("Content-Disposition")); err == nil {
+		part.Filename = params["filename"]
+	}
+
+	if strings.HasPrefix(mediaType, "text/") && part.Filename == "" {
+		body, err := ioutil.ReadAll(e.Body)
+		if err != nil {
+			return err
+		}
+		part.Text = string(body)
+	}
+
+	art.Parts = append(art.Parts, part)
+	return nil
+}
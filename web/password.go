@@ -0,0 +1,75 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+)
+
+// MinPasswordLength is the minimum length of the passwords set from the web
+const MinPasswordLength = 8
+
+// PasswordRequester records a password for an e-mail address and sends the
+// mail the address owner replies to in order to set it
+type PasswordRequester interface {
+	RequestPassword(email, pass string) error
+}
+
+type passwordPage struct {
+	CSRF  string
+	Email string
+	Error string
+}
+
+// handlePassword serves /password where visitors ask for the password used to
+// log in with AUTHINFO
+func (w *Web) handlePassword(rw http.ResponseWriter, r *http.Request) {
+	if w.Passwords == nil {
+		http.NotFound(rw, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.render(rw, passwordTemplate, passwordPage{CSRF: w.csrfToken(rw, r)})
+
+	case http.MethodPost:
+		w.submitPassword(rw, r)
+
+	default:
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (w *Web) submitPassword(rw http.ResponseWriter, r *http.Request) {
+	var data = passwordPage{Email: singleLine(r.PostFormValue("email"))}
+	var pass = r.PostFormValue("password")
+
+	if !w.checkCSRF(r) {
+		http.Error(rw, "Invalid form token, please reload the form", http.StatusForbidden)
+		return
+	}
+	data.CSRF = w.csrfToken(rw, r)
+
+	addr, err := mail.ParseAddress(data.Email)
+	if err != nil || addr.Address != data.Email {
+		data.Error = "Invalid e-mail address"
+	} else if len(pass) < MinPasswordLength {
+		data.Error = fmt.Sprintf("The password must have at least %d characters", MinPasswordLength)
+	} else if pass != r.PostFormValue("confirm") {
+		data.Error = "The passwords do not match"
+	}
+	if data.Error == "" {
+		err = w.Passwords.RequestPassword(data.Email, pass)
+		if err != nil {
+			data.Error = fmt.Sprintf("Password request failed: %v", err)
+		}
+	}
+	if data.Error != "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		w.render(rw, passwordTemplate, data)
+		return
+	}
+
+	w.render(rw, passwordSentTemplate, data)
+}
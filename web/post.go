@@ -0,0 +1,187 @@
+package web
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mildred/newsweb/articles"
+)
+
+const csrfCookie = "csrf"
+
+// Poster posts an article through the same pipeline as NNTP POST
+type Poster interface {
+	Post(article io.Reader) error
+}
+
+type postPage struct {
+	Group      *articles.Group
+	CSRF       string
+	Name       string
+	Email      string
+	Subject    string
+	References string
+	Body       string
+	Error      string
+}
+
+// handlePost serves /groups/NAME/post, optionally replying to ?reply=NUM
+func (w *Web) handlePost(rw http.ResponseWriter, r *http.Request, grp *articles.Group) {
+	switch r.Method {
+	case http.MethodGet:
+		var data = postPage{Group: grp, CSRF: w.csrfToken(rw, r)}
+		if reply := r.URL.Query().Get("reply"); reply != "" {
+			num, err := strconv.ParseInt(reply, 10, 64)
+			if err != nil {
+				http.NotFound(rw, r)
+				return
+			}
+			err = w.fillReply(&data, grp.Name, num)
+			if err != nil {
+				w.fault(rw, err)
+				return
+			}
+		}
+		w.render(rw, postTemplate, data)
+
+	case http.MethodPost:
+		w.submitPost(rw, r, grp)
+
+	default:
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (w *Web) fillReply(data *postPage, groupName string, num int64) error {
+	f, _, err := w.Articles.GetArticleNum(groupName, num)
+	if err != nil || f == nil {
+		return err
+	}
+	defer f.Close()
+
+	parent, err := ReadArticle(f)
+	if err != nil {
+		return err
+	}
+
+	data.Subject = parent.Subject
+	if !strings.HasPrefix(strings.ToLower(data.Subject), "re:") {
+		data.Subject = "Re: " + data.Subject
+	}
+	data.References = strings.TrimSpace(parent.References + " " + parent.MsgId)
+	return nil
+}
+
+func (w *Web) submitPost(rw http.ResponseWriter, r *http.Request, grp *articles.Group) {
+	var data = postPage{
+		Group:      grp,
+		Name:       singleLine(r.PostFormValue("name")),
+		Email:      singleLine(r.PostFormValue("email")),
+		Subject:    singleLine(r.PostFormValue("subject")),
+		References: singleLine(r.PostFormValue("references")),
+		Body:       r.PostFormValue("body"),
+	}
+
+	if !w.checkCSRF(r) {
+		http.Error(rw, "Invalid form token, please reload the form", http.StatusForbidden)
+		return
+	}
+	data.CSRF = w.csrfToken(rw, r)
+
+	addr, err := mail.ParseAddress(data.Email)
+	if err != nil {
+		data.Error = "Invalid e-mail address"
+	} else if data.Subject == "" {
+		data.Error = "The subject is required"
+	} else if strings.TrimSpace(data.Body) == "" {
+		data.Error = "The message is empty"
+	}
+	if data.Error != "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		w.render(rw, postTemplate, data)
+		return
+	}
+
+	from := &mail.Address{Name: data.Name, Address: addr.Address}
+	article := w.buildArticle(from, grp.Name, data.Subject, data.References, data.Body)
+
+	err = w.Poster.Post(bytes.NewReader(article))
+	if err != nil {
+		data.Error = fmt.Sprintf("Posting failed: %v", err)
+		rw.WriteHeader(http.StatusBadRequest)
+		w.render(rw, postTemplate, data)
+		return
+	}
+
+	w.render(rw, postedTemplate, data)
+}
+
+// buildArticle creates an RFC 5322 article with a generated Message-ID
+func (w *Web) buildArticle(from *mail.Address, group, subject, references, body string) []byte {
+	var art bytes.Buffer
+	fmt.Fprintf(&art, "From: %s\n", from.String())
+	fmt.Fprintf(&art, "Newsgroups: %s\n", group)
+	fmt.Fprintf(&art, "Subject: %s\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&art, "Date: %s\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&art, "Message-ID: <%s@%s>\n", genToken(16), w.Domain)
+	if references != "" {
+		fmt.Fprintf(&art, "References: %s\n", references)
+		refs := strings.Fields(references)
+		fmt.Fprintf(&art, "In-Reply-To: %s\n", refs[len(refs)-1])
+	}
+	fmt.Fprintf(&art, "MIME-Version: 1.0\n")
+	fmt.Fprintf(&art, "Content-Type: text/plain; charset=utf-8\n")
+	fmt.Fprintf(&art, "Content-Transfer-Encoding: 8bit\n")
+	fmt.Fprintf(&art, "\n")
+	art.WriteString(strings.Replace(body, "\r\n", "\n", -1))
+	if !strings.HasSuffix(body, "\n") {
+		art.WriteString("\n")
+	}
+	return art.Bytes()
+}
+
+// csrfToken returns the token of the double-submit cookie, creating it if
+// needed.
+func (w *Web) csrfToken(rw http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+
+	token := genToken(32)
+	http.SetCookie(rw, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+func (w *Web) checkCSRF(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.PostFormValue("csrf"))) == 1
+}
+
+func singleLine(s string) string {
+	return strings.TrimSpace(strings.NewReplacer("\r", " ", "\n", " ").Replace(s))
+}
+
+func genToken(size int) string {
+	var data = make([]byte, size)
+	_, _ = rand.Read(data)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
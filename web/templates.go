@@ -0,0 +1,147 @@
+package web
+
+import (
+	"html/template"
+)
+
+const layoutHeader = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{block "title" .}}newsweb{{end}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: auto; padding: 1em; }
+table { border-collapse: collapse; width: 100%; }
+td, th { text-align: left; padding: 0.2em 0.5em; border-bottom: 1px solid #ddd; }
+pre { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+`
+
+const layoutFooter = `
+</body>
+</html>
+`
+
+var indexTemplate = template.Must(template.New("index").Parse(layoutHeader + `
+<h1>Newsgroups</h1>
+<table>
+<tr><th>Group</th><th>Articles</th><th>Description</th></tr>
+{{range .}}
+<tr>
+<td><a href="/groups/{{.Name}}/">{{.Name}}</a></td>
+<td>{{.Count}}</td>
+<td>{{.Description}}</td>
+</tr>
+{{else}}
+<tr><td colspan="3">No groups</td></tr>
+{{end}}
+</table>
+<p><a href="/password">Set a password</a> to log in with your newsreader</p>
+` + layoutFooter))
+
+var groupTemplate = template.Must(template.New("group").Parse(layoutHeader + `
+<p><a href="/">Newsgroups</a></p>
+<h1>{{.Group.Name}}</h1>
+<p>{{.Group.Description}}</p>
+<p><a href="/groups/{{.Group.Name}}/post">New article</a></p>
+<table>
+<tr><th>#</th><th>Subject</th><th>From</th><th>Date</th></tr>
+{{$group := .Group.Name}}
+{{range .Articles}}
+<tr>
+<td>{{.Num}}</td>
+<td><a href="/groups/{{$group}}/{{.Num}}">{{.Subject}}</a></td>
+<td>{{.From}}</td>
+<td>{{.Date}}</td>
+</tr>
+{{else}}
+<tr><td colspan="4">No articles</td></tr>
+{{end}}
+</table>
+<p>
+{{if ge .PrevPage 0}}<a href="?page={{.PrevPage}}">Newer</a>{{end}}
+{{if .NextPage}}<a href="?page={{.NextPage}}">Older</a>{{end}}
+</p>
+` + layoutFooter))
+
+var articleTemplate = template.Must(template.New("article").Parse(layoutHeader + `
+<p><a href="/">Newsgroups</a> &gt; <a href="/groups/{{.Group.Name}}/">{{.Group.Name}}</a></p>
+<p><a href="/groups/{{.Group.Name}}/post?reply={{.Article.Num}}">Reply</a></p>
+{{with .Article}}
+<h1>{{.Subject}}</h1>
+<table>
+<tr><th>From</th><td>{{.From}}</td></tr>
+<tr><th>Date</th><td>{{.Date}}</td></tr>
+<tr><th>Newsgroups</th><td>{{.Newsgroups}}</td></tr>
+<tr><th>Message-ID</th><td>{{.MsgId}}</td></tr>
+</table>
+{{range .Parts}}
+{{if .Text}}
+<pre>{{.Text}}</pre>
+{{else}}
+<p>Attachment: {{.MediaType}} {{.Filename}}</p>
+{{end}}
+{{end}}
+{{end}}
+{{with .Thread}}
+<h2>Thread</h2>
+<ul>{{template "node" .}}</ul>
+{{end}}
+` + layoutFooter + `
+{{define "node"}}
+<li>
+{{if .Current}}<strong>{{.Subject}}</strong> {{.From}}
+{{else if .Num}}<a href="{{.Num}}">{{.Subject}}</a> {{.From}}
+{{else}}<em>article not available</em>
+{{end}}
+{{if .Children}}<ul>{{range .Children}}{{template "node" .}}{{end}}</ul>{{end}}
+</li>
+{{end}}`))
+
+var postTemplate = template.Must(template.New("post").Parse(layoutHeader + `
+<p><a href="/">Newsgroups</a> &gt; <a href="/groups/{{.Group.Name}}/">{{.Group.Name}}</a></p>
+<h1>Post to {{.Group.Name}}</h1>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+<form method="post" action="/groups/{{.Group.Name}}/post">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+<input type="hidden" name="references" value="{{.References}}">
+<p><label>Name<br><input name="name" value="{{.Name}}" size="40"></label></p>
+<p><label>E-mail<br><input name="email" type="email" value="{{.Email}}" size="40" required></label></p>
+<p><label>Subject<br><input name="subject" value="{{.Subject}}" size="60" required></label></p>
+<p><label>Message<br><textarea name="body" rows="20" cols="72" required>{{.Body}}</textarea></label></p>
+<p><button type="submit">Post</button></p>
+</form>
+` + layoutFooter))
+
+var postedTemplate = template.Must(template.New("posted").Parse(layoutHeader + `
+<p><a href="/">Newsgroups</a> &gt; <a href="/groups/{{.Group.Name}}/">{{.Group.Name}}</a></p>
+<h1>Check your inbox</h1>
+<p>Your article was received and a confirmation mail was sent to {{.Email}}.
+Reply to it to confirm you are the author, your article will be published once
+confirmed.</p>
+` + layoutFooter))
+
+var passwordTemplate = template.Must(template.New("password").Parse(layoutHeader + `
+<p><a href="/">Newsgroups</a></p>
+<h1>Set a password</h1>
+<p>The password lets your newsreader log in with your e-mail address as user
+name. A confirmation mail is sent to the address, the password is set once you
+reply to it.</p>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+<form method="post" action="/password">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+<p><label>E-mail<br><input name="email" type="email" value="{{.Email}}" size="40" required></label></p>
+<p><label>Password<br><input name="password" type="password" size="40" required></label></p>
+<p><label>Confirm password<br><input name="confirm" type="password" size="40" required></label></p>
+<p><button type="submit">Request password</button></p>
+</form>
+` + layoutFooter))
+
+var passwordSentTemplate = template.Must(template.New("password-sent").Parse(layoutHeader + `
+<p><a href="/">Newsgroups</a></p>
+<h1>Check your inbox</h1>
+<p>A confirmation mail was sent to {{.Email}}. Reply to it to confirm you own
+this address, your password will be set once confirmed.</p>
+` + layoutFooter))
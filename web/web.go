@@ -0,0 +1,272 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mildred/newsweb/articles"
+)
+
+const PageSize = 50
+
+// Timeouts of the HTTP connections, so that slow or idle clients cannot hold
+// them open forever
+const (
+	ReadTimeout  = 30 * time.Second
+	WriteTimeout = 60 * time.Second
+	IdleTimeout  = 2 * time.Minute
+)
+
+// Web serves the newsgroups over HTTP for reading in a browser
+type Web struct {
+	Articles   *articles.Articles
+	Poster     Poster
+	Passwords  PasswordRequester
+	ListenAddr string
+	// Domain used to generate Message-IDs
+	Domain string
+}
+
+func (w *Web) Start(ctx context.Context, wg *sync.WaitGroup) error {
+	if w.ListenAddr == "" {
+		return nil
+	}
+
+	srv := &http.Server{
+		Addr:         w.ListenAddr,
+		Handler:      w.Handler(),
+		ReadTimeout:  ReadTimeout,
+		WriteTimeout: WriteTimeout,
+		IdleTimeout:  IdleTimeout,
+	}
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		log.Print("INFO: Closing HTTP server...")
+		t, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := srv.Shutdown(t)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		log.Printf("INFO: Started HTTP server on %s", w.ListenAddr)
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("ERROR: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+func (w *Web) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", w.handleIndex)
+	mux.HandleFunc("/groups/", w.handleGroup)
+	mux.HandleFunc("/password", w.handlePassword)
+	return mux
+}
+
+func (w *Web) handleIndex(rw http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(rw, r)
+		return
+	}
+
+	grps, err := w.Articles.ListGroups()
+	if err != nil {
+		w.fault(rw, err)
+		return
+	}
+
+	var res []*articles.Group
+	for _, grp := range grps {
+		if canRead(grp) {
+			res = append(res, grp)
+		}
+	}
+
+	w.render(rw, indexTemplate, res)
+}
+
+// handleGroup serves /groups/NAME/, /groups/NAME/post and /groups/NAME/NUM
+func (w *Web) handleGroup(rw http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/groups/"), "/")
+	if len(path) != 2 {
+		http.NotFound(rw, r)
+		return
+	}
+
+	grp, err := w.Articles.GetGroup(path[0])
+	if err == articles.ErrNoGroup || (err == nil && !canRead(grp)) {
+		http.NotFound(rw, r)
+		return
+	} else if err != nil {
+		w.fault(rw, err)
+		return
+	}
+
+	if path[1] == "" {
+		w.handleArticleList(rw, r, grp)
+		return
+	} else if path[1] == "post" {
+		w.handlePost(rw, r, grp)
+		return
+	}
+
+	num, err := strconv.ParseInt(path[1], 10, 64)
+	if err != nil {
+		http.NotFound(rw, r)
+		return
+	}
+	w.handleArticle(rw, r, grp, num)
+}
+
+type articleListPage struct {
+	Group    *articles.Group
+	Articles []*ArticleSummary
+	Page     int
+	PrevPage int
+	NextPage int
+}
+
+func (w *Web) handleArticleList(rw http.ResponseWriter, r *http.Request, grp *articles.Group) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 0 {
+		page = 0
+	}
+
+	var data = articleListPage{Group: grp, Page: page, PrevPage: page - 1}
+
+	// Newest articles first
+	high := grp.High - int64(page*PageSize)
+	low := high - PageSize + 1
+	if low <= grp.Low {
+		// The oldest article is on this page
+		low = grp.Low
+	} else {
+		data.NextPage = page + 1
+	}
+
+	overviews, err := w.Articles.GetOverview(grp.Name, low, high)
+	if err != nil {
+		w.fault(rw, err)
+		return
+	}
+	for i := len(overviews) - 1; i >= 0; i-- {
+		data.Articles = append(data.Articles, summaryFromOverview(overviews[i]))
+	}
+
+	w.render(rw, groupTemplate, data)
+}
+
+type articlePage struct {
+	Group   *articles.Group
+	Article *Article
+	Thread  *threadNode
+}
+
+type threadNode struct {
+	*ArticleSummary
+	Current  bool
+	Children []*threadNode
+}
+
+func (w *Web) handleArticle(rw http.ResponseWriter, r *http.Request, grp *articles.Group, num int64) {
+	f, _, err := w.Articles.GetArticleNum(grp.Name, num)
+	if err != nil {
+		w.fault(rw, err)
+		return
+	} else if f == nil {
+		http.NotFound(rw, r)
+		return
+	}
+	defer f.Close()
+
+	art, err := ReadArticle(f)
+	if err != nil {
+		w.fault(rw, err)
+		return
+	}
+	art.Num = num
+
+	var thread *threadNode
+	t, err := w.Articles.GetThread(grp.Name, art.MsgId)
+	if err == nil {
+		thread, err = w.readThread(grp.Name, t.Root, num)
+	}
+	if err != nil && err != articles.ErrNoThread {
+		w.fault(rw, err)
+		return
+	}
+
+	w.render(rw, articleTemplate, articlePage{grp, art, thread})
+}
+
+func (w *Web) readThread(groupName string, node *articles.ThreadNode, current int64) (*threadNode, error) {
+	var res = &threadNode{Current: node.Num == current}
+	if node.Num != 0 {
+		summary, err := w.readSummary(groupName, node.Num)
+		if err != nil {
+			return nil, err
+		}
+		res.ArticleSummary = summary
+	}
+	if res.ArticleSummary == nil {
+		res.ArticleSummary = &ArticleSummary{MsgId: node.MsgId}
+	}
+
+	for _, child := range node.Children {
+		c, err := w.readThread(groupName, child, current)
+		if err != nil {
+			return nil, err
+		}
+		res.Children = append(res.Children, c)
+	}
+	return res, nil
+}
+
+func (w *Web) readSummary(groupName string, num int64) (*ArticleSummary, error) {
+	f, _, err := w.Articles.GetArticleNum(groupName, num)
+	if err != nil || f == nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	art, err := ReadSummary(f)
+	if err != nil {
+		return nil, err
+	}
+	art.Num = num
+	return art, nil
+}
+
+func (w *Web) render(rw http.ResponseWriter, tmpl *template.Template, data interface{}) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := tmpl.Execute(rw, data)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+}
+
+func (w *Web) fault(rw http.ResponseWriter, err error) {
+	log.Printf("ERROR: %v", err)
+	http.Error(rw, fmt.Sprintf("%d %s", http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError)), http.StatusInternalServerError)
+}
+
+// canRead tells if anonymous web visitors can read the group
+func canRead(grp *articles.Group) bool {
+	return grp.Policy != articles.PolicyMembersOnly
+}